@@ -3,6 +3,9 @@ package explain
 import (
 	"errors"
 	"fmt"
+
+	"github.com/changsongl/gorm-plugin/fingerprint"
+	"github.com/changsongl/gorm-plugin/settings"
 	"gorm.io/gorm"
 )
 
@@ -12,72 +15,120 @@ const (
 	ExplainCMD = "EXPLAIN"
 )
 
-// CallBackResult call back result
+// CallBackResult call back result, Fingerprint is the normalized
+// sql and Digest is the digest of it, they are same for the same query
+// with different parameters. QueryName is the value of settings.QueryName.
 type CallBackResult struct {
-	Err     error
-	Results []Result
-	SQL     string
+	Err         error
+	Results     []Result
+	SQL         string
+	Fingerprint string
+	Digest      string
+	QueryName   string
 }
 
-// callback struct
-type callback struct {
-	explain *Explainer
-	enable  func() bool
-	fn      func(CallBackResult)
+// StatementExplainer runs EXPLAIN of gorm statements, and analyzes
+// the results with explain options. The explain plugin uses it after
+// every statement, it can be used by other plugins too.
+type StatementExplainer struct {
+	explain         *Explainer
+	enable          func() bool
+	fingerprintOpts []fingerprint.Option
 }
 
-// newCallBack new a call back
-func newCallBack(opts *options) *callback {
-	return &callback{
-		enable:  opts.enable,
-		fn:      opts.fn,
-		explain: NewExplainer(opts.explainOpts),
+// NewStatementExplainer return a StatementExplainer, CallBackFuncOption
+// is ignored.
+func NewStatementExplainer(opts ...Option) *StatementExplainer {
+	options := newOptions()
+	for _, optFunc := range opts {
+		optFunc.apply(options)
 	}
+	return newStatementExplainer(options)
 }
 
-// Register explain function to all callback processes
-func (c *callback) Register(db *gorm.DB) error {
-	var explainCB func(gormDB *gorm.DB)
-	explainCB = func(gormDB *gorm.DB) {
-		if gormDB.Error != nil && gormDB.Error != gorm.ErrRecordNotFound {
-			gormDB.Logger.Warn(gormDB.Statement.Context, fmt.Sprintf("Explain call back failed: %s", gormDB.Error.Error()))
-			return
-		}
+// newStatementExplainer return a StatementExplainer of options
+func newStatementExplainer(opts *options) *StatementExplainer {
+	return &StatementExplainer{
+		enable:          opts.enable,
+		explain:         NewExplainer(opts.explainOpts),
+		fingerprintOpts: opts.fingerprintOpts,
+	}
+}
 
-		if c.enable != nil && !c.enable() {
-			gormDB.Logger.Info(gormDB.Statement.Context, "Explain call back not enable")
-			return
-		}
+// Explain runs EXPLAIN of the statement of gormDB, and returns the
+// result. It returns false if the statement failed, explain is not
+// enabled or skipped by settings.SkipExplain, or EXPLAIN failed.
+// Failures are logged by gormDB.Logger.
+func (e *StatementExplainer) Explain(gormDB *gorm.DB) (CallBackResult, bool) {
+	if gormDB.Error != nil && gormDB.Error != gorm.ErrRecordNotFound {
+		gormDB.Logger.Warn(gormDB.Statement.Context, fmt.Sprintf("Explain call back failed: %s", gormDB.Error.Error()))
+		return CallBackResult{}, false
+	}
 
-		sql := gormDB.Dialector.Explain(gormDB.Statement.SQL.String(), gormDB.Statement.Vars...)
-		explainSQL := fmt.Sprintf("%s %s", ExplainCMD, sql)
+	if e.enable != nil && !e.enable() {
+		gormDB.Logger.Info(gormDB.Statement.Context, "Explain call back not enable")
+		return CallBackResult{}, false
+	}
 
-		conn, err := gormDB.DB()
-		if err != nil {
-			gormDB.Logger.Error(gormDB.Statement.Context, err.Error())
-			return
-		}
+	s := settings.Load(gormDB)
+	if s.SkipExplain {
+		return CallBackResult{}, false
+	}
 
-		rows, err := conn.Query(explainSQL)
-		if err != nil {
-			gormDB.Logger.Error(gormDB.Statement.Context, err.Error())
-			return
-		}
+	sql := gormDB.Dialector.Explain(gormDB.Statement.SQL.String(), gormDB.Statement.Vars...)
+	explainSQL := fmt.Sprintf("%s %s", ExplainCMD, sql)
 
-		result, recom, err := c.explain.Analyze(rows)
+	conn, err := gormDB.DB()
+	if err != nil {
+		gormDB.Logger.Error(gormDB.Statement.Context, err.Error())
+		return CallBackResult{}, false
+	}
 
-		if err != nil {
-			gormDB.Logger.Error(gormDB.Statement.Context, fmt.Sprintf("Query: %s, Error: %s", explainSQL, err.Error()))
-			return
-		}
+	rows, err := conn.Query(explainSQL)
+	if err != nil {
+		gormDB.Logger.Error(gormDB.Statement.Context, err.Error())
+		return CallBackResult{}, false
+	}
+
+	result, recom, err := e.explain.Analyze(rows)
+
+	if err != nil {
+		gormDB.Logger.Error(gormDB.Statement.Context, fmt.Sprintf("Query: %s, Error: %s", explainSQL, err.Error()))
+		return CallBackResult{}, false
+	}
 
-		if c.fn != nil {
-			var resErr error
-			if recom != EmptyRecommendation {
-				resErr = errors.New(recom)
-			}
+	var resErr error
+	if recom != EmptyRecommendation {
+		resErr = errors.New(recom)
+	}
+
+	normalized, digest := fingerprint.Fingerprint(gormDB.Statement.SQL.String(), e.fingerprintOpts...)
+	return CallBackResult{
+		Results: result, Err: resErr, SQL: sql,
+		Fingerprint: normalized, Digest: digest, QueryName: s.QueryName,
+	}, true
+}
+
+// callback struct
+type callback struct {
+	explainer *StatementExplainer
+	fn        func(CallBackResult)
+}
 
-			c.fn(CallBackResult{Results: result, Err: resErr, SQL: sql})
+// newCallBack new a call back
+func newCallBack(opts *options) *callback {
+	return &callback{
+		fn:        opts.fn,
+		explainer: newStatementExplainer(opts),
+	}
+}
+
+// Register explain function to all callback processes
+func (c *callback) Register(db *gorm.DB) error {
+	explainCB := func(gormDB *gorm.DB) {
+		result, ok := c.explainer.Explain(gormDB)
+		if ok && c.fn != nil {
+			c.fn(result)
 		}
 	}
 
@@ -107,3 +158,32 @@ func (c *callback) Register(db *gorm.DB) error {
 
 	return nil
 }
+
+// Remove explain function from all callback processes
+func (c *callback) Remove(db *gorm.DB) error {
+	if err := db.Callback().Create().Remove(namePrefix + "gorm:create"); err != nil {
+		return err
+	}
+
+	if err := db.Callback().Delete().Remove(namePrefix + "gorm:delete"); err != nil {
+		return err
+	}
+
+	if err := db.Callback().Query().Remove(namePrefix + "gorm:query"); err != nil {
+		return err
+	}
+
+	if err := db.Callback().Update().Remove(namePrefix + "gorm:update"); err != nil {
+		return err
+	}
+
+	if err := db.Callback().Row().Remove(namePrefix + "gorm:row"); err != nil {
+		return err
+	}
+
+	if err := db.Callback().Raw().Remove(namePrefix + "gorm:raw"); err != nil {
+		return err
+	}
+
+	return nil
+}
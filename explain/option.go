@@ -1,10 +1,13 @@
 package explain
 
+import "github.com/changsongl/gorm-plugin/fingerprint"
+
 // options option data
 type options struct {
-	enable      func() bool
-	fn          func(CallBackResult)
-	explainOpts explainerOptions
+	enable          func() bool
+	fn              func(CallBackResult)
+	explainOpts     explainerOptions
+	fingerprintOpts []fingerprint.Option
 }
 
 // Option interface to apply changes on options
@@ -86,3 +89,12 @@ func TypeLevelOption(typeLevel ResultType) Option {
 		opt.explainOpts.TypeLevel = typeLevel
 	})
 }
+
+// ANSIQuotesOption treats double quoted strings as identifiers in
+// Fingerprint of CallBackResult, like the ANSI_QUOTES sql mode of MySQL.
+// By default they are string literals.
+func ANSIQuotesOption() Option {
+	return optFunc(func(opt *options) {
+		opt.fingerprintOpts = []fingerprint.Option{fingerprint.ANSIQuotes()}
+	})
+}
@@ -4,6 +4,12 @@ import (
 	"gorm.io/gorm"
 )
 
+// Plugin is a gorm plugin which can be closed
+type Plugin interface {
+	gorm.Plugin
+	Close(db *gorm.DB) error
+}
+
 // plugin
 type plugin struct {
 	cb *callback
@@ -19,8 +25,18 @@ func (p plugin) Initialize(db *gorm.DB) error {
 	return p.cb.Register(db)
 }
 
+// Close plugin, it removes explain callbacks and the plugin from db
+func (p plugin) Close(db *gorm.DB) error {
+	if err := p.cb.Remove(db); err != nil {
+		return err
+	}
+
+	delete(db.Config.Plugins, p.Name())
+	return nil
+}
+
 // New a explain plugin
-func New(opts ...Option) gorm.Plugin {
+func New(opts ...Option) Plugin {
 	options := newOptions()
 	for _, optFunc := range opts {
 		optFunc.apply(options)
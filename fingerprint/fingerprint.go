@@ -0,0 +1,359 @@
+// Package fingerprint normalizes SQL statements, so the same query with
+// different parameters has the same fingerprint and digest.
+package fingerprint
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token kinds
+const (
+	tokenWord = iota
+	tokenQuoted
+	tokenValue
+	tokenPunct
+	tokenOperator
+)
+
+// placeholder of literals and bind variables
+const (
+	placeholder     = "?"
+	listPlaceholder = "?+"
+)
+
+// token is a lexical unit of SQL.
+type token struct {
+	kind int
+	text string
+}
+
+// Fingerprint return the normalized sql and its digest.
+func Fingerprint(sql string, opts ...Option) (normalized, digest string) {
+	normalized = Normalize(sql, opts...)
+	return normalized, Sum(normalized)
+}
+
+// Digest return the digest of normalized sql.
+func Digest(sql string, opts ...Option) string {
+	return Sum(Normalize(sql, opts...))
+}
+
+// Sum return the digest of a normalized sql, it is 16 hex characters.
+func Sum(normalized string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(normalized))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// Normalize sql. It strips comments and literals, replaces literals and
+// bind variables with ?, collapses IN lists into (?+), keeps the first
+// tuple of VALUES lists, lower cases keywords and identifiers which are
+// not quoted, and normalizes whitespace. Double quoted strings are
+// literals, unless ANSIQuotes is in opts.
+func Normalize(sql string, opts ...Option) string {
+	tokens := collapseValues(collapseIn(tokenize(sql, newOptions(opts))))
+	return render(tokens)
+}
+
+// tokenize split sql into tokens, comments are dropped.
+func tokenize(sql string, opts options) []token {
+	var tokens []token
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case isSpace(c):
+			i++
+		case c == '#', c == '-' && strings.HasPrefix(sql[i:], "--"):
+			i = skipLine(sql, i)
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+		case c == '\'', c == '"' && !opts.ansiQuotes:
+			i = skipQuoted(sql, i, c)
+			tokens = append(tokens, token{kind: tokenValue, text: placeholder})
+		case c == '"', c == '`':
+			end := skipQuoted(sql, i, c)
+			tokens = append(tokens, token{kind: tokenQuoted, text: sql[i:end]})
+			i = end
+		case isDigit(c), c == '.' && i+1 < len(sql) && isDigit(sql[i+1]):
+			i = skipNumber(sql, i)
+			tokens = append(tokens, token{kind: tokenValue, text: placeholder})
+		case (c == '-' || c == '+') && isNumberAt(sql, skipSpaces(sql, i+1)) && unary(tokens):
+			i = skipNumber(sql, skipSpaces(sql, i+1))
+			tokens = append(tokens, token{kind: tokenValue, text: placeholder})
+		case c == '?':
+			i++
+			tokens = append(tokens, token{kind: tokenValue, text: placeholder})
+		case c == '$' && i+1 < len(sql) && isDigit(sql[i+1]):
+			for i++; i < len(sql) && isDigit(sql[i]); i++ {
+			}
+			tokens = append(tokens, token{kind: tokenValue, text: placeholder})
+		case strings.IndexByte("(),;.", c) >= 0:
+			i++
+			tokens = append(tokens, token{kind: tokenPunct, text: string(c)})
+		case strings.IndexByte("=<>!|&+-*/%^~:", c) >= 0:
+			end := i + 1
+			for end < len(sql) && strings.IndexByte("=<>!|&+-*/%^~:", sql[end]) >= 0 &&
+				!strings.HasPrefix(sql[end:], "--") && !strings.HasPrefix(sql[end:], "/*") &&
+				!((sql[end] == '-' || sql[end] == '+') && isNumberAt(sql, skipSpaces(sql, end+1))) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: sql[i:end]})
+			i = end
+		default:
+			end := skipWord(sql, i)
+			tokens = append(tokens, token{kind: tokenWord, text: strings.ToLower(sql[i:end])})
+			i = end
+		}
+	}
+
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	return tokens
+}
+
+// unaryKeywords are keywords which can be followed by a signed number.
+var unaryKeywords = map[string]bool{
+	"select": true, "where": true, "and": true, "or": true, "not": true,
+	"when": true, "then": true, "else": true, "between": true, "like": true,
+	"limit": true, "offset": true, "by": true, "having": true, "on": true,
+	"return": true, "values": true, "value": true, "set": true, "is": true,
+}
+
+// unary check whether a sign after tokens is a unary one, such as the
+// minus of "id = -1", but not the one of "a - 1". So signed numbers are
+// values, and have the same fingerprint as unsigned ones.
+func unary(tokens []token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+
+	prev := tokens[len(tokens)-1]
+	switch prev.kind {
+	case tokenOperator:
+		return true
+	case tokenPunct:
+		return prev.text != ")"
+	case tokenWord:
+		return unaryKeywords[prev.text]
+	}
+	return false
+}
+
+// collapseIn replace lists of values after IN with a (?+).
+func collapseIn(tokens []token) []token {
+	out := tokens[:0:0]
+	for i := 0; i < len(tokens); i++ {
+		out = append(out, tokens[i])
+		if tokens[i].kind != tokenWord || tokens[i].text != "in" {
+			continue
+		}
+
+		if end, ok := valueList(tokens, i+1); ok {
+			out = append(out,
+				token{kind: tokenPunct, text: "("},
+				token{kind: tokenValue, text: listPlaceholder},
+				token{kind: tokenPunct, text: ")"},
+			)
+			i = end
+		}
+	}
+	return out
+}
+
+// valueList check whether tokens from start is a list of values in
+// parentheses, and return the index of the closing parenthesis.
+func valueList(tokens []token, start int) (int, bool) {
+	if start >= len(tokens) || tokens[start].text != "(" {
+		return 0, false
+	}
+
+	expectValue := true
+	for i := start + 1; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case expectValue && t.kind == tokenValue:
+			expectValue = false
+		case !expectValue && t.text == ",":
+			expectValue = true
+		case !expectValue && t.text == ")":
+			return i, true
+		default:
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// collapseValues keep only the first tuple of VALUES lists.
+func collapseValues(tokens []token) []token {
+	out := tokens[:0:0]
+	for i := 0; i < len(tokens); i++ {
+		out = append(out, tokens[i])
+		if tokens[i].kind != tokenWord || (tokens[i].text != "values" && tokens[i].text != "value") {
+			continue
+		}
+
+		end, ok := tuple(tokens, i+1)
+		if !ok {
+			continue
+		}
+		out = append(out, tokens[i+1:end+1]...)
+		i = end
+
+		for i+1 < len(tokens) && tokens[i+1].text == "," {
+			next, ok := tuple(tokens, i+2)
+			if !ok {
+				break
+			}
+			i = next
+		}
+	}
+	return out
+}
+
+// tuple return the index of the parenthesis closing the one at start.
+func tuple(tokens []token, start int) (int, bool) {
+	if start >= len(tokens) || tokens[start].text != "(" {
+		return 0, false
+	}
+
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		switch tokens[i].text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// render join tokens with single spaces. There is no space after "("
+// and ".", and no space before ")", "," and ".".
+func render(tokens []token) string {
+	var b strings.Builder
+	for i, t := range tokens {
+		if i > 0 {
+			prev := tokens[i-1]
+			noSpace := prev.kind == tokenPunct && (prev.text == "(" || prev.text == ".") ||
+				t.kind == tokenPunct && (t.text == ")" || t.text == "," || t.text == ".")
+			if !noSpace {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+// skipLine return the index after the end of line from i.
+func skipLine(sql string, i int) int {
+	if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+		return i + end + 1
+	}
+	return len(sql)
+}
+
+// skipBlockComment return the index after the block comment at i.
+func skipBlockComment(sql string, i int) int {
+	if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+		return i + 2 + end + 2
+	}
+	return len(sql)
+}
+
+// skipQuoted return the index after the quoted string at i. Quotes are
+// escaped by backslash or doubled quotes.
+func skipQuoted(sql string, i int, quote byte) int {
+	for j := i + 1; j < len(sql); j++ {
+		switch sql[j] {
+		case '\\':
+			if quote != '`' {
+				j++
+			}
+		case quote:
+			if j+1 < len(sql) && sql[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(sql)
+}
+
+// skipNumber return the index after the number at i, including
+// decimals, exponents and hex numbers.
+func skipNumber(sql string, i int) int {
+	j := i
+	if strings.HasPrefix(sql[i:], "0x") || strings.HasPrefix(sql[i:], "0X") {
+		for j = i + 2; j < len(sql) && isHex(sql[j]); j++ {
+		}
+		return j
+	}
+
+	for ; j < len(sql); j++ {
+		c := sql[j]
+		switch {
+		case isDigit(c), c == '.':
+		case (c == 'e' || c == 'E') && j+1 < len(sql):
+			if sql[j+1] == '+' || sql[j+1] == '-' {
+				j++
+			}
+		default:
+			return j
+		}
+	}
+	return j
+}
+
+// skipSpaces return the index of the first non space character from i.
+func skipSpaces(sql string, i int) int {
+	for i < len(sql) && isSpace(sql[i]) {
+		i++
+	}
+	return i
+}
+
+// isNumberAt check whether a number starts at i.
+func isNumberAt(sql string, i int) bool {
+	return i < len(sql) && (isDigit(sql[i]) || sql[i] == '.' && i+1 < len(sql) && isDigit(sql[i+1]))
+}
+
+// skipWord return the index after the word at i.
+func skipWord(sql string, i int) int {
+	j := i
+	for j < len(sql) {
+		r, size := utf8.DecodeRuneInString(sql[j:])
+		if r != '_' && r != '$' && r != '@' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		j += size
+	}
+	if j == i {
+		_, size := utf8.DecodeRuneInString(sql[i:])
+		j += size
+	}
+	return j
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
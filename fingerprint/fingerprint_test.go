@@ -0,0 +1,66 @@
+package fingerprint
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{"string literal", "SELECT * FROM users WHERE name = 'bob'", "select * from users where name = ?"},
+		{"escaped string", `SELECT * FROM users WHERE name = 'it''s' AND note = 'a\'b'`, "select * from users where name = ? and note = ?"},
+		{"numbers", "SELECT * FROM users WHERE id = 1 AND score > 1.5e3 AND flag = 0xFF", "select * from users where id = ? and score > ? and flag = ?"},
+		{"bind vars", "SELECT * FROM users WHERE id = ? AND age > $2", "select * from users where id = ? and age > ?"},
+		{"negative number", "SELECT * FROM users WHERE id = -1", "select * from users where id = ?"},
+		{"negative without spaces", "SELECT * FROM users WHERE id=-1", "select * from users where id = ?"},
+		{"positive sign", "SELECT * FROM users WHERE id = +1", "select * from users where id = ?"},
+		{"signed in select", "SELECT -1, - 2", "select ?, ?"},
+		{"binary minus", "SELECT a-1 FROM t WHERE b - 2 > c", "select a - ? from t where b - ? > c"},
+		{"minus after parenthesis", "SELECT (a) - 1 FROM t", "select (a) - ? from t"},
+		{"in list", "SELECT * FROM users WHERE id IN (1, 2, 3)", "select * from users where id in (?+)"},
+		{"in list of signed", "SELECT * FROM users WHERE id IN (-1, 2)", "select * from users where id in (?+)"},
+		{"in list of binds", "SELECT * FROM users WHERE id IN (?,?)", "select * from users where id in (?+)"},
+		{"in subquery", "SELECT * FROM users WHERE id IN (SELECT id FROM t)", "select * from users where id in (select id from t)"},
+		{"values", "INSERT INTO users (name, age) VALUES ('a', 1), ('b', 2), ('c', -3)", "insert into users (name, age) values (?, ?)"},
+		{"value", "INSERT INTO users (name) VALUE (?), (?)", "insert into users (name) value (?)"},
+		{"line comment", "SELECT * FROM users -- all users\nWHERE id = 1", "select * from users where id = ?"},
+		{"hash comment", "SELECT * FROM users # all users\nWHERE id = 1", "select * from users where id = ?"},
+		{"block comment", "SELECT /* hint */ * FROM users", "select * from users"},
+		{"backquoted", "SELECT `Name` FROM `Users`", "select `Name` from `Users`"},
+		{"double quoted string", `SELECT * FROM users WHERE name = "bob" AND note = "say ""hi"""`, "select * from users where name = ? and note = ?"},
+		{"qualified", "SELECT u.id FROM db.users u", "select u.id from db.users u"},
+		{"whitespace", "  SELECT\t*\n FROM   users ;", "select * from users"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Normalize(c.sql); got != c.want {
+				t.Errorf("Normalize(%q) = %q, want %q", c.sql, got, c.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeANSIQuotes(t *testing.T) {
+	sql := `SELECT "Name" FROM "Users" WHERE name = 'bob'`
+	want := `select "Name" from "Users" where name = ?`
+	if got := Normalize(sql, ANSIQuotes()); got != want {
+		t.Errorf("Normalize(%q, ANSIQuotes()) = %q, want %q", sql, got, want)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	if Digest("SELECT * FROM users WHERE id = -1") != Digest("select * from users where id = 2") {
+		t.Error("signed and unsigned numbers have different digests")
+	}
+	if Digest("SELECT * FROM users") == Digest("SELECT * FROM orders") {
+		t.Error("different queries have the same digest")
+	}
+	if Digest(`SELECT * FROM users WHERE name = "bob"`) != Digest(`SELECT * FROM users WHERE name = "alice"`) {
+		t.Error("double quoted strings have different digests")
+	}
+	if d := Digest("SELECT 1"); len(d) != 16 {
+		t.Errorf("digest %q is not 16 characters", d)
+	}
+}
@@ -0,0 +1,36 @@
+package fingerprint
+
+// options option data
+type options struct {
+	ansiQuotes bool
+}
+
+// Option interface to apply changes on options
+type Option interface {
+	apply(*options)
+}
+
+// optFunc option function
+type optFunc func(*options)
+
+// apply implements Option
+func (f optFunc) apply(opts *options) {
+	f(opts)
+}
+
+// newOptions create options of opts
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	return o
+}
+
+// ANSIQuotes treats double quoted strings as identifiers, like the
+// ANSI_QUOTES sql mode of MySQL. By default they are string literals.
+func ANSIQuotes() Option {
+	return optFunc(func(opt *options) {
+		opt.ansiQuotes = true
+	})
+}
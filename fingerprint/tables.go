@@ -0,0 +1,145 @@
+package fingerprint
+
+// aliasStopWords are words which can follow a table name, but are not
+// its alias.
+var aliasStopWords = map[string]bool{
+	"where": true, "join": true, "inner": true, "left": true, "right": true,
+	"full": true, "outer": true, "cross": true, "natural": true, "straight_join": true,
+	"on": true, "using": true, "group": true, "order": true, "limit": true,
+	"having": true, "window": true, "union": true, "except": true, "intersect": true,
+	"set": true, "values": true, "value": true, "select": true, "default": true,
+	"for": true, "lock": true, "partition": true, "use": true, "ignore": true,
+	"force": true, "returning": true, "offset": true, "fetch": true,
+}
+
+// tableModifiers are words which can be between a keyword and the table.
+var tableModifiers = map[string]bool{
+	"low_priority": true, "delayed": true, "high_priority": true,
+	"ignore": true, "quick": true, "only": true, "lateral": true,
+}
+
+// Tables return table names of sql in order of appearance without
+// duplicates. They are targets of FROM, JOIN, INTO and UPDATE, and the
+// tables listed after them with commas. Schema qualifiers and quotes
+// are removed, names which are not quoted are lower cased. FROM in
+// parentheses without SELECT, such as EXTRACT(YEAR FROM created_at), is
+// not followed by tables. Double quoted names are tables only if
+// ANSIQuotes is in opts.
+func Tables(sql string, opts ...Option) []string {
+	tokens := tokenize(sql, newOptions(opts))
+
+	var tables []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if name != "" && name != "dual" && !seen[name] {
+			seen[name] = true
+			tables = append(tables, name)
+		}
+	}
+
+	// selects[d] is whether parentheses of depth d+1 are a subquery.
+	var selects []bool
+	for i := 0; i < len(tokens); i++ {
+		switch t := tokens[i]; {
+		case t.text == "(":
+			selects = append(selects, false)
+		case t.text == ")" && len(selects) > 0:
+			selects = selects[:len(selects)-1]
+		case t.kind == tokenWord && t.text == "select" && len(selects) > 0:
+			selects[len(selects)-1] = true
+		}
+
+		if !isTableKeyword(tokens, i) {
+			continue
+		}
+		if tokens[i].text == "from" && len(selects) > 0 && !selects[len(selects)-1] {
+			continue
+		}
+
+		j := i + 1
+		for j < len(tokens) && tokens[j].kind == tokenWord && tableModifiers[tokens[j].text] {
+			j++
+		}
+		for {
+			name, end, ok := tableName(tokens, j)
+			if !ok {
+				break
+			}
+			add(name)
+			j = skipAlias(tokens, end)
+			if j >= len(tokens) || tokens[j].text != "," {
+				break
+			}
+			j++
+		}
+		i = j - 1
+	}
+	return tables
+}
+
+// isTableKeyword check whether the token at i is followed by tables.
+func isTableKeyword(tokens []token, i int) bool {
+	t := tokens[i]
+	if t.kind != tokenWord {
+		return false
+	}
+
+	switch t.text {
+	case "from", "join", "into", "straight_join":
+		return true
+	case "update":
+		// skip ON DUPLICATE KEY UPDATE and FOR UPDATE
+		return i == 0 || tokens[i-1].text != "key" && tokens[i-1].text != "for"
+	}
+	return false
+}
+
+// tableName return the table name at i and the index after it.
+func tableName(tokens []token, i int) (string, int, bool) {
+	var name string
+	for {
+		if i >= len(tokens) {
+			return "", i, false
+		}
+
+		t := tokens[i]
+		switch {
+		case t.kind == tokenQuoted:
+			name = unquote(t.text)
+		case t.kind == tokenWord && !aliasStopWords[t.text]:
+			name = t.text
+		default:
+			return "", i, false
+		}
+		i++
+
+		// schema.table
+		if i+1 < len(tokens) && tokens[i].text == "." {
+			i++
+			continue
+		}
+		return name, i, true
+	}
+}
+
+// skipAlias return the index after the alias of a table at i.
+func skipAlias(tokens []token, i int) int {
+	if i < len(tokens) && tokens[i].kind == tokenWord && tokens[i].text == "as" {
+		i++
+	}
+	if i < len(tokens) && (tokens[i].kind == tokenQuoted ||
+		tokens[i].kind == tokenWord && !aliasStopWords[tokens[i].text]) {
+		i++
+	}
+	return i
+}
+
+// unquote remove quotes of a quoted identifier, it may be unterminated.
+func unquote(s string) string {
+	quote := s[0]
+	s = s[1:]
+	if len(s) > 0 && s[len(s)-1] == quote {
+		s = s[:len(s)-1]
+	}
+	return s
+}
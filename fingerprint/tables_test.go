@@ -0,0 +1,53 @@
+package fingerprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTables(t *testing.T) {
+	cases := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"select", "SELECT * FROM users WHERE id = 1", []string{"users"}},
+		{"alias", "SELECT u.id FROM users AS u WHERE u.id = 1", []string{"users"}},
+		{"comma list", "SELECT * FROM users u, orders o WHERE u.id = o.user_id", []string{"users", "orders"}},
+		{"join", "SELECT * FROM users u LEFT JOIN orders o ON o.user_id = u.id JOIN items ON 1 = 1", []string{"users", "orders", "items"}},
+		{"schema and quotes", "SELECT * FROM `shop`.`Orders`", []string{"Orders"}},
+		{"insert", "INSERT INTO users (name) VALUES (?)", []string{"users"}},
+		{"insert ignore", "INSERT IGNORE INTO users (name) VALUES (?)", []string{"users"}},
+		{"insert select", "INSERT INTO archive (id) SELECT id FROM users", []string{"archive", "users"}},
+		{"on duplicate key update", "INSERT INTO users (id) VALUES (1) ON DUPLICATE KEY UPDATE id = 1", []string{"users"}},
+		{"update", "UPDATE LOW_PRIORITY users SET name = ? WHERE id = ?", []string{"users"}},
+		{"delete", "DELETE FROM users WHERE id = ?", []string{"users"}},
+		{"for update", "SELECT * FROM users WHERE id = 1 FOR UPDATE", []string{"users"}},
+		{"subquery", "SELECT * FROM (SELECT id FROM users) t", []string{"users"}},
+		{"in subquery", "SELECT * FROM orders WHERE user_id IN (SELECT id FROM users)", []string{"orders", "users"}},
+		{"extract", "SELECT EXTRACT(YEAR FROM created_at) FROM orders", []string{"orders"}},
+		{"extract in where", "SELECT id FROM orders WHERE EXTRACT(YEAR FROM created_at) = 2020", []string{"orders"}},
+		{"substring", "SELECT SUBSTRING(name FROM 2 FOR 3) FROM users", []string{"users"}},
+		{"trim in subquery", "SELECT * FROM (SELECT TRIM(LEADING 'x' FROM name) FROM users) t", []string{"users"}},
+		{"dual", "SELECT 1 FROM dual", nil},
+		{"duplicates", "SELECT * FROM users JOIN users", []string{"users"}},
+		{"no table", "SELECT 1", nil},
+		{"double quoted string", `SELECT * FROM users WHERE name = "orders"`, []string{"users"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Tables(c.sql); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Tables(%q) = %q, want %q", c.sql, got, c.want)
+			}
+		})
+	}
+}
+
+func TestTablesANSIQuotes(t *testing.T) {
+	sql := `SELECT * FROM "shop"."Orders" o JOIN "users" u ON u.id = o.user_id`
+	want := []string{"Orders", "users"}
+	if got := Tables(sql, ANSIQuotes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tables(%q, ANSIQuotes()) = %q, want %q", sql, got, want)
+	}
+}
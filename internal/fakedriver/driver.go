@@ -0,0 +1,74 @@
+// Package fakedriver is a sql driver for tests of gorm-plugin packages.
+// Every query returns Rows rows of id, every exec affects Rows rows, and
+// transactions commit unless the dsn is CommitFailedDSN.
+package fakedriver
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+)
+
+// Name is the registered name of the driver.
+const Name = "gorm-plugin-fake"
+
+// Rows is the number of rows of every statement.
+const Rows = 3
+
+// CommitFailedDSN is the dsn whose transactions fail to commit.
+const CommitFailedDSN = "commit_failed"
+
+// ErrCommitFailed is returned by Commit of transactions of CommitFailedDSN.
+var ErrCommitFailed = errors.New("fakedriver: commit failed")
+
+func init() {
+	sql.Register(Name, fakeDriver{})
+}
+
+type fakeDriver struct{}
+
+// Open implements driver.Driver
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	return conn{dsn: dsn}, nil
+}
+
+type conn struct{ dsn string }
+
+func (conn) Prepare(string) (driver.Stmt, error) { return stmt{}, nil }
+func (conn) Close() error                        { return nil }
+func (c conn) Begin() (driver.Tx, error)         { return tx(c), nil }
+
+type tx struct{ dsn string }
+
+// Commit implements driver.Tx
+func (t tx) Commit() error {
+	if t.dsn == CommitFailedDSN {
+		return ErrCommitFailed
+	}
+	return nil
+}
+
+// Rollback implements driver.Tx
+func (tx) Rollback() error { return nil }
+
+type stmt struct{}
+
+func (stmt) Close() error                               { return nil }
+func (stmt) NumInput() int                              { return -1 }
+func (stmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(Rows), nil }
+func (stmt) Query([]driver.Value) (driver.Rows, error)  { return &idRows{}, nil }
+
+// idRows are rows of id from 1 to Rows.
+type idRows struct{ i int }
+
+func (*idRows) Columns() []string { return []string{"id"} }
+func (*idRows) Close() error      { return nil }
+func (r *idRows) Next(dest []driver.Value) error {
+	if r.i >= Rows {
+		return io.EOF
+	}
+	r.i++
+	dest[0] = int64(r.i)
+	return nil
+}
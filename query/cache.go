@@ -0,0 +1,118 @@
+package query
+
+import (
+	"sync"
+)
+
+// metricKey is the label values of a cached metric.
+type metricKey struct {
+	table     string
+	callback  string
+	errorKind string
+	digest    string
+	queryName string
+	// extra is extra label values joined by extraLabelSep.
+	extra string
+}
+
+// value return the value of label in key.
+func (k metricKey) value(label string) string {
+	switch label {
+	case labelTableName:
+		return k.table
+	case labelCallbackName:
+		return k.callback
+	case labelErrorKind:
+		return k.errorKind
+	case labelDigest:
+		return k.digest
+	case labelQueryName:
+		return k.queryName
+	}
+	return ""
+}
+
+// labelValuesFunc return label values of a metricKey in order of
+// the labels of a metric vector.
+type labelValuesFunc func(key metricKey) []string
+
+// keyValues return a labelValuesFunc of labels, the last extra
+// labels of them are extra labels.
+func keyValues(labels []string, extra int) labelValuesFunc {
+	builtin := labels[:len(labels)-extra]
+	return func(key metricKey) []string {
+		lvs := make([]string, len(builtin), len(labels))
+		for i, label := range builtin {
+			lvs[i] = key.value(label)
+		}
+		if extra > 0 {
+			lvs = append(lvs, splitExtraValues(key.extra, extra)...)
+		}
+		return lvs
+	}
+}
+
+// counterCache caches counters of a CounterVec by metricKey, so
+// recording a query needs neither a label map nor label hashing.
+type counterCache struct {
+	vec CounterVec
+	lvs labelValuesFunc
+	mu  sync.RWMutex
+	m   map[metricKey]Counter
+}
+
+// newCounterCache return a counterCache of vec, labels are the
+// variable labels of vec, the last extra of them are extra labels.
+func newCounterCache(vec CounterVec, labels []string, extra int) *counterCache {
+	return &counterCache{vec: vec, lvs: keyValues(labels, extra), m: map[metricKey]Counter{}}
+}
+
+// get return the counter of key.
+func (c *counterCache) get(key metricKey) Counter {
+	c.mu.RLock()
+	counter, ok := c.m[key]
+	c.mu.RUnlock()
+	if ok {
+		return counter
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter, ok = c.m[key]; !ok {
+		counter = c.vec.WithLabelValues(c.lvs(key)...)
+		c.m[key] = counter
+	}
+	return counter
+}
+
+// observerCache caches observers of a HistogramVec by metricKey.
+type observerCache struct {
+	vec HistogramVec
+	lvs labelValuesFunc
+	mu  sync.RWMutex
+	m   map[metricKey]Observer
+}
+
+// newObserverCache return a observerCache of vec, labels are the
+// variable labels of vec, the last extra of them are extra labels.
+func newObserverCache(vec HistogramVec, labels []string, extra int) *observerCache {
+	return &observerCache{vec: vec, lvs: keyValues(labels, extra), m: map[metricKey]Observer{}}
+}
+
+// get return the observer of key.
+func (c *observerCache) get(key metricKey) Observer {
+	c.mu.RLock()
+	observer, ok := c.m[key]
+	c.mu.RUnlock()
+	if ok {
+		return observer
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if observer, ok = c.m[key]; !ok {
+		observer = c.vec.WithLabelValues(c.lvs(key)...)
+		c.m[key] = observer
+	}
+	return observer
+}
@@ -0,0 +1,47 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BenchmarkLabelMap records a counter with a label map, it is how
+// metrics were recorded before counterCache.
+func BenchmarkLabelMap(b *testing.B) {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "bench"}, []string{labelDbName, labelTableName, labelCallbackName})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		vec.With(map[string]string{
+			labelDbName:       "db",
+			labelTableName:    "users",
+			labelCallbackName: "gorm:query",
+		}).Inc()
+	}
+}
+
+func BenchmarkCounterCacheHit(b *testing.B) {
+	labels := []string{labelTableName, labelCallbackName}
+	cache := newCounterCache(PrometheusMeter{}.NewCounter(MetricOpts{Name: "bench", Labels: labels}), labels, 0)
+	key := metricKey{table: "users", callback: "gorm:query"}
+	cache.get(key)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.get(key).Inc()
+	}
+}
+
+func TestCounterCacheHitAllocs(t *testing.T) {
+	labels := []string{labelTableName, labelCallbackName}
+	cache := newCounterCache(PrometheusMeter{}.NewCounter(MetricOpts{Name: "test", Labels: labels}), labels, 0)
+	key := metricKey{table: "users", callback: "gorm:query"}
+	if cache.get(key) != cache.get(key) {
+		t.Fatal("counter of the same key is not cached")
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { cache.get(key).Inc() }); allocs != 0 {
+		t.Fatalf("allocs per hit = %v, want 0", allocs)
+	}
+}
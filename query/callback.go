@@ -2,8 +2,13 @@ package query
 
 import (
 	"fmt"
-	"gorm.io/gorm"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/changsongl/gorm-plugin/settings"
+	"gorm.io/gorm"
 )
 
 // Handler is gorm v2 callback function
@@ -15,58 +20,124 @@ type Handler func(db *gorm.DB)
 type Interceptor func(string) func(next Handler) Handler
 
 // slowQueryMetricInterceptor return a slow query Interceptor.
-func slowQueryMetricInterceptor(max time.Duration, metric *slowMetric) Interceptor {
+// It logs every slow query if slowLog is not nil. Statements with
+// settings.SkipMetrics are skipped, and settings.SlowThreshold overrides
+// the slow threshold.
+func slowQueryMetricInterceptor(runtime *Runtime, metric *slowMetric, slowLog *SlowQueryLogOption) Interceptor {
 	return func(cbName string) func(next Handler) Handler {
 		return func(originHandler Handler) Handler {
 			return func(db *gorm.DB) {
-				start := time.Now()
+				start := StartTime(db)
 				originHandler(db)
 				cost := time.Since(start)
-				metric.timeQuery(db.Statement.Table, cost)
 
-				if cost < max {
+				state, s, table := runtime.state(), statementSettings(db), metric.labeler.table(db)
+				if !recordable(state, s, table) {
 					return
 				}
-				metric.incSlowQuery(db.Statement.Table, cbName)
+
+				key := metric.labeler.key(db, cbName)
+				key.queryName = s.QueryName
+				if state.sampled() {
+					metric.timeQuery(key, cost)
+				}
+
+				threshold := s.SlowThreshold
+				if threshold <= 0 {
+					threshold = state.thresholds.get(table, cbName)
+				}
+				if cost < threshold {
+					return
+				}
+				metric.incSlowQuery(key, db.Statement.SQL.String())
+				slowLog.log(db, cbName, table, s.QueryName, start, cost, metric.fingerprintOpts)
 			}
 		}
 	}
 }
 
 // errorQueryMetricInterceptor return a error query Interceptor.
-func errorQueryMetricInterceptor(metric *errorMetric) Interceptor {
+func errorQueryMetricInterceptor(runtime *Runtime, metric *errorMetric) Interceptor {
+	return func(cbName string) func(next Handler) Handler {
+		return func(originHandler Handler) Handler {
+			return func(db *gorm.DB) {
+				originHandler(db)
+				s := statementSettings(db)
+				if !recordable(runtime.state(), s, metric.labeler.table(db)) {
+					return
+				}
+				if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
+					key := metric.labeler.key(db, cbName)
+					key.queryName = s.QueryName
+					metric.incErrorQuery(key, db.Error)
+				}
+			}
+		}
+	}
+}
+
+// recordable return true if metrics of a statement should be recorded,
+// s is the settings of the statement, and table is its table.
+func recordable(state *runtimeState, s settings.Settings, table string) bool {
+	return !s.SkipMetrics && state.enabled(table)
+}
+
+// rowsMetricInterceptor return a rows Interceptor. Rows of gorm:query are
+// rows returned, rows of gorm:create, gorm:update, gorm:delete and gorm:raw
+// (db.Exec) are rows affected. Rows of gorm:row are not scanned yet when
+// the callback returns, so they are left pending in the statement context,
+// and recorded as rows returned by rowsLogger after db.Scan.
+func rowsMetricInterceptor(runtime *Runtime, metric *rowsMetric) Interceptor {
 	return func(cbName string) func(next Handler) Handler {
 		return func(originHandler Handler) Handler {
 			return func(db *gorm.DB) {
 				originHandler(db)
 				if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
-					metric.incErrorQuery(db.Statement.Table, cbName)
+					return
+				}
+				if state := runtime.state(); !recordable(state, statementSettings(db), metric.labeler.table(db)) || !state.sampled() {
+					return
+				}
+
+				key := metric.labeler.key(db, cbName)
+				if cbName == "gorm:row" {
+					withPendingRows(db, key)
+				} else if cbName == "gorm:query" {
+					metric.observeReturned(key, db.RowsAffected)
+				} else {
+					metric.observeAffected(key, db.RowsAffected)
 				}
 			}
 		}
 	}
 }
 
+// metricCallback is for installing an Interceptor into gorm callbacks.
 type metricCallback interface {
 	getDb() *gorm.DB
 	getInterceptor() Interceptor
+	getMode() Mode
 }
 
 // slowCallback is for replace callback with slow metrics.
 type slowCallback struct {
-	db            *gorm.DB
-	slowThreshold time.Duration
-	metric        *slowMetric
-	interceptor   Interceptor
+	db          *gorm.DB
+	runtime     *Runtime
+	metric      *slowMetric
+	slowLog     *SlowQueryLogOption
+	interceptor Interceptor
+	mode        Mode
 }
 
 // newSlowCallback return a slowCallback.
-func newSlowCallback(db *gorm.DB, slowThreshold time.Duration, metric *slowMetric) metricCallback {
+func newSlowCallback(db *gorm.DB, runtime *Runtime, metric *slowMetric, slowLog *SlowQueryLogOption, mode Mode) metricCallback {
 	return &slowCallback{
-		db:            db,
-		slowThreshold: slowThreshold,
-		metric:        metric,
-		interceptor:   slowQueryMetricInterceptor(slowThreshold, metric),
+		db:          db,
+		runtime:     runtime,
+		metric:      metric,
+		slowLog:     slowLog,
+		interceptor: slowQueryMetricInterceptor(runtime, metric, slowLog),
+		mode:        mode,
 	}
 }
 
@@ -78,19 +149,27 @@ func (r *slowCallback) getInterceptor() Interceptor {
 	return r.interceptor
 }
 
+func (r *slowCallback) getMode() Mode {
+	return r.mode
+}
+
 // errorCallback is for replace callback with error metrics.
 type errorCallback struct {
 	db          *gorm.DB
+	runtime     *Runtime
 	metric      *errorMetric
 	interceptor Interceptor
+	mode        Mode
 }
 
 // newErrorCallback return a errorCallback.
-func newErrorCallback(db *gorm.DB, metric *errorMetric) metricCallback {
+func newErrorCallback(db *gorm.DB, runtime *Runtime, metric *errorMetric, mode Mode) metricCallback {
 	return &errorCallback{
 		db:          db,
+		runtime:     runtime,
 		metric:      metric,
-		interceptor: errorQueryMetricInterceptor(metric),
+		interceptor: errorQueryMetricInterceptor(runtime, metric),
+		mode:        mode,
 	}
 }
 
@@ -102,68 +181,257 @@ func (e errorCallback) getInterceptor() Interceptor {
 	return e.interceptor
 }
 
-// replaceAllCallback is for crazy ladder function calls,
-// which replace all callbacks.
-func replaceAllCallback(m metricCallback) {
-	replaceRowCallback(
-		replaceRawCallback(
-			replaceQueryCallback(
-				replaceDeleteCallback(
-					replaceUpdateCallback(
-						replaceCreateCallback(m))))))
+func (e errorCallback) getMode() Mode {
+	return e.mode
+}
+
+// interceptorCallback is for replace callback with user interceptors.
+type interceptorCallback struct {
+	db          *gorm.DB
+	interceptor Interceptor
+	mode        Mode
+}
+
+// newInterceptorCallback return a interceptorCallback.
+func newInterceptorCallback(db *gorm.DB, interceptor Interceptor, mode Mode) metricCallback {
+	return &interceptorCallback{
+		db:          db,
+		interceptor: interceptor,
+		mode:        mode,
+	}
+}
+
+func (i *interceptorCallback) getDb() *gorm.DB {
+	return i.db
+}
+
+func (i *interceptorCallback) getInterceptor() Interceptor {
+	return i.interceptor
+}
+
+func (i *interceptorCallback) getMode() Mode {
+	return i.mode
+}
+
+// chainInterceptors chains interceptors into one Interceptor. The first
+// interceptor is the outermost one, so it runs first before the origin
+// Handler and last after it.
+func chainInterceptors(interceptors ...Interceptor) Interceptor {
+	return func(cbName string) func(next Handler) Handler {
+		return func(originHandler Handler) Handler {
+			h := originHandler
+			for i := len(interceptors) - 1; i >= 0; i-- {
+				h = interceptors[i](cbName)(h)
+			}
+			return h
+		}
+	}
+}
+
+// callbackErrors is a group of errors from replacing callbacks.
+type callbackErrors []error
+
+// Error implements error interface, it joins all error messages.
+func (errs callbackErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// append add err to errs, errors of err will be flattened
+// if it is a callbackErrors.
+func (errs callbackErrors) append(err error) callbackErrors {
+	if group, ok := err.(callbackErrors); ok {
+		return append(errs, group...)
+	}
+	return append(errs, err)
+}
+
+// errOrNil return nil if there is no error.
+func (errs callbackErrors) errOrNil() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// restoreFunc restores gorm callbacks changed by an installation.
+type restoreFunc func() error
+
+// restoreAll return a restoreFunc running restores in reverse order,
+// it keeps going when one of them failed, and return all errors.
+func restoreAll(restores []restoreFunc) restoreFunc {
+	return func() error {
+		var errs callbackErrors
+		for i := len(restores) - 1; i >= 0; i-- {
+			if err := restores[i](); err != nil {
+				errs = errs.append(err)
+			}
+		}
+		return errs.errOrNil()
+	}
+}
+
+// replaceAllCallback replace all callbacks, or register hooks around
+// them in HookMode. It keeps going when one of them failed, and return
+// all errors. The returned restoreFunc undoes the succeeded ones.
+func replaceAllCallback(m metricCallback) (restoreFunc, error) {
+	var errs callbackErrors
+	var restores []restoreFunc
+	for _, replace := range []func(metricCallback) (restoreFunc, error){
+		replaceCreateCallback,
+		replaceUpdateCallback,
+		replaceDeleteCallback,
+		replaceQueryCallback,
+		replaceRawCallback,
+		replaceRowCallback,
+	} {
+		restore, err := replace(m)
+		if restore != nil {
+			restores = append(restores, restore)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return restoreAll(restores), errs.errOrNil()
 }
 
 // replaceCreateCallback replace create callback.
-func replaceCreateCallback(m metricCallback) metricCallback {
-	db, interceptor, cb := m.getDb(), m.getInterceptor(), "gorm:create"
-	err := db.Callback().Create().Replace(cb, interceptor(cb)(db.Callback().Create().Get(cb)))
-	panicCallbackError(err)
-	return m
+func replaceCreateCallback(m metricCallback) (restoreFunc, error) {
+	db, cb := m.getDb(), "gorm:create"
+	p := db.Callback().Create()
+	if m.getMode() == HookMode {
+		return registerHooks(m, p, cb, p.Before(cb).Register, p.After(cb).Register, p.Remove)
+	}
+	return replaceCallback(p, cb, m.getInterceptor())
 }
 
 // replaceDeleteCallback replace delete callback.
-func replaceDeleteCallback(m metricCallback) metricCallback {
-	db, interceptor, cb := m.getDb(), m.getInterceptor(), "gorm:delete"
-	err := db.Callback().Delete().Replace(cb, interceptor(cb)(db.Callback().Delete().Get(cb)))
-	panicCallbackError(err)
-	return m
+func replaceDeleteCallback(m metricCallback) (restoreFunc, error) {
+	db, cb := m.getDb(), "gorm:delete"
+	p := db.Callback().Delete()
+	if m.getMode() == HookMode {
+		return registerHooks(m, p, cb, p.Before(cb).Register, p.After(cb).Register, p.Remove)
+	}
+	return replaceCallback(p, cb, m.getInterceptor())
 }
 
-// replaceDeleteCallback replace delete callback.
-func replaceQueryCallback(m metricCallback) metricCallback {
-	db, interceptor, cb := m.getDb(), m.getInterceptor(), "gorm:query"
-	err := db.Callback().Query().Replace(cb, interceptor(cb)(db.Callback().Query().Get(cb)))
-	panicCallbackError(err)
-	return m
+// replaceQueryCallback replace query callback.
+func replaceQueryCallback(m metricCallback) (restoreFunc, error) {
+	db, cb := m.getDb(), "gorm:query"
+	p := db.Callback().Query()
+	if m.getMode() == HookMode {
+		return registerHooks(m, p, cb, p.Before(cb).Register, p.After(cb).Register, p.Remove)
+	}
+	return replaceCallback(p, cb, m.getInterceptor())
 }
 
 // replaceUpdateCallback replace update callback.
-func replaceUpdateCallback(m metricCallback) metricCallback {
-	db, interceptor, cb := m.getDb(), m.getInterceptor(), "gorm:update"
-	err := db.Callback().Update().Replace(cb, interceptor(cb)(db.Callback().Update().Get(cb)))
-	panicCallbackError(err)
-	return m
+func replaceUpdateCallback(m metricCallback) (restoreFunc, error) {
+	db, cb := m.getDb(), "gorm:update"
+	p := db.Callback().Update()
+	if m.getMode() == HookMode {
+		return registerHooks(m, p, cb, p.Before(cb).Register, p.After(cb).Register, p.Remove)
+	}
+	return replaceCallback(p, cb, m.getInterceptor())
 }
 
 // replaceRowCallback replace row callback.
-func replaceRowCallback(m metricCallback) metricCallback {
-	db, interceptor, cb := m.getDb(), m.getInterceptor(), "gorm:row"
-	err := db.Callback().Row().Replace(cb, interceptor(cb)(db.Callback().Row().Get(cb)))
-	panicCallbackError(err)
-	return m
+func replaceRowCallback(m metricCallback) (restoreFunc, error) {
+	db, cb := m.getDb(), "gorm:row"
+	p := db.Callback().Row()
+	if m.getMode() == HookMode {
+		return registerHooks(m, p, cb, p.Before(cb).Register, p.After(cb).Register, p.Remove)
+	}
+	return replaceCallback(p, cb, m.getInterceptor())
 }
 
 // replaceRawCallback replace raw callback.
-func replaceRawCallback(m metricCallback) metricCallback {
-	db, interceptor, cb := m.getDb(), m.getInterceptor(), "gorm:raw"
-	err := db.Callback().Raw().Replace(cb, interceptor(cb)(db.Callback().Raw().Get(cb)))
-	panicCallbackError(err)
-	return m
+func replaceRawCallback(m metricCallback) (restoreFunc, error) {
+	db, cb := m.getDb(), "gorm:raw"
+	p := db.Callback().Raw()
+	if m.getMode() == HookMode {
+		return registerHooks(m, p, cb, p.Before(cb).Register, p.After(cb).Register, p.Remove)
+	}
+	return replaceCallback(p, cb, m.getInterceptor())
+}
+
+// processor is the part of gorm callback processor we use.
+type processor interface {
+	Get(name string) func(*gorm.DB)
+	Replace(name string, fn func(*gorm.DB)) error
+}
+
+// getCallback return the cb handler of p, and an error naming the
+// callback if it is missing or removed.
+func getCallback(p processor, cb string) (func(*gorm.DB), error) {
+	handler := p.Get(cb)
+	if handler == nil || removed(p, cb) {
+		return nil, fmt.Errorf("query plugin: callback %s not found", cb)
+	}
+	return handler, nil
+}
+
+// removed return true if callback cb of p is removed. Get of gorm
+// processor skips the removing callback, and returns the removed handler,
+// replacing it would add the callback back. The callbacks of processor are
+// not exported, so they are read by reflection, it returns false if p is
+// not a gorm processor.
+func removed(p processor, cb string) bool {
+	v := reflect.Indirect(reflect.ValueOf(p))
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+	callbacks := v.FieldByName("callbacks")
+	if callbacks.Kind() != reflect.Slice {
+		return false
+	}
+
+	for i := callbacks.Len() - 1; i >= 0; i-- {
+		c := reflect.Indirect(callbacks.Index(i))
+		name, remove := c.FieldByName("name"), c.FieldByName("remove")
+		if name.Kind() != reflect.String || remove.Kind() != reflect.Bool {
+			return false
+		}
+		if name.String() == cb {
+			return remove.Bool()
+		}
+	}
+	return false
 }
 
-// panicCallbackError panic with error.
-func panicCallbackError(err error) {
+// replaceCallback wrap the cb handler of p with interceptor, and return
+// an error naming the callback if the handler is missing or the
+// replacement failed. The returned restoreFunc closes the wrapper, so it
+// passes statements to the origin handler. The wrapper is not replaced
+// back, other plugins may have wrapped it after us, they are kept.
+func replaceCallback(p processor, cb string, interceptor Interceptor) (restoreFunc, error) {
+	origin, err := getCallback(p, cb)
 	if err != nil {
-		panic(fmt.Sprintf("SlowQueryCallback plugin failed: %s\n", err.Error()))
+		return nil, err
+	}
+
+	var closed int32
+	wrapped := interceptor(cb)(origin)
+	handler := func(db *gorm.DB) {
+		if atomic.LoadInt32(&closed) == 1 {
+			origin(db)
+			return
+		}
+		resetStatementSettings(db)
+		wrapped(db)
+	}
+	if err := p.Replace(cb, handler); err != nil {
+		return nil, fmt.Errorf("query plugin: replace callback %s failed: %w", cb, err)
+	}
+
+	restore := func() error {
+		atomic.StoreInt32(&closed, 1)
+		return nil
 	}
+	return restore, nil
 }
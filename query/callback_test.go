@@ -0,0 +1,52 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// newBenchHandler return a handler of gorm:query wrapped by slow query
+// and error interceptors, and a failed statement of users table.
+func newBenchHandler(tb testing.TB) (Handler, *gorm.DB) {
+	runtime, err := NewRuntime(RuntimeConfig{SlowThreshold: time.Nanosecond})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	slow := newSlowMetric(PrometheusMeter{}, "gorm", "bench", "db", slowMetricConfig{buckets: DefaultBuckets})
+	errs := newErrorMetric(PrometheusMeter{}, "gorm", "bench", "db", nil, nil, nil)
+	handler := chainInterceptors(
+		slowQueryMetricInterceptor(runtime, slow, nil),
+		errorQueryMetricInterceptor(runtime, errs),
+	)("gorm:query")(func(db *gorm.DB) {})
+
+	db := &gorm.DB{Statement: &gorm.Statement{Table: "users", Context: context.Background()}}
+	db.Error = gorm.ErrInvalidData
+	return handler, db
+}
+
+func TestInterceptorsAllocs(t *testing.T) {
+	handler, db := newBenchHandler(t)
+	handler(db)
+
+	// settings are loaded again by every callback of the statement.
+	run := func() {
+		resetStatementSettings(db)
+		handler(db)
+	}
+	if allocs := testing.AllocsPerRun(100, run); allocs != 0 {
+		t.Fatalf("allocs per query = %v, want 0", allocs)
+	}
+}
+
+func BenchmarkInterceptors(b *testing.B) {
+	handler, db := newBenchHandler(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler(db)
+	}
+}
@@ -0,0 +1,37 @@
+package query
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/changsongl/gorm-plugin/internal/fakedriver"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newDryRunDB return a dry run mysql db, it never connects to the
+// server. Default transactions are skipped, so create, update and delete
+// do not begin one.
+func newDryRunDB(tb testing.TB) *gorm.DB {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "user:pass@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true, Logger: logger.Discard})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// newFakeDB return a mysql db of fakedriver with dsn, every query of it
+// returns fakedriver.Rows rows.
+func newFakeDB(tb testing.TB, dsn string) *gorm.DB {
+	sqlDB, err := sql.Open(fakedriver.Name, dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,88 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// ErrorClassifier returns the kind of err for error_kind label. It should
+// return a small bounded set of kinds to keep label cardinality low.
+type ErrorClassifier func(err error) string
+
+// error kinds of DefaultErrorClassifier
+const (
+	ErrorKindDeadlock        = "deadlock"
+	ErrorKindLockWaitTimeout = "lock_wait_timeout"
+	ErrorKindDuplicateKey    = "duplicate_key"
+	ErrorKindTimeout         = "timeout"
+	ErrorKindCanceled        = "canceled"
+	ErrorKindBadConn         = "bad_conn"
+	ErrorKindGorm            = "gorm"
+	ErrorKindMySQL           = "mysql"
+	ErrorKindOther           = "other"
+)
+
+// mysql error numbers
+const (
+	mysqlErrDuplicateKey    = 1062
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+)
+
+// gormErrors are gorm sentinel errors classified as ErrorKindGorm.
+var gormErrors = []error{
+	gorm.ErrInvalidTransaction,
+	gorm.ErrNotImplemented,
+	gorm.ErrMissingWhereClause,
+	gorm.ErrUnsupportedRelation,
+	gorm.ErrPrimaryKeyRequired,
+	gorm.ErrModelValueRequired,
+	gorm.ErrInvalidData,
+	gorm.ErrUnsupportedDriver,
+	gorm.ErrRegistered,
+	gorm.ErrInvalidField,
+	gorm.ErrEmptySlice,
+	gorm.ErrDryRunModeUnsupported,
+	gorm.ErrInvalidDB,
+	gorm.ErrInvalidValue,
+	gorm.ErrInvalidValueOfLength,
+}
+
+// DefaultErrorClassifier classifies mysql deadlock, lock wait timeout and
+// duplicate key errors, context deadline and cancel errors, bad connection
+// errors and gorm errors. Other mysql errors are ErrorKindMySQL, and the
+// rest are ErrorKindOther.
+func DefaultErrorClassifier(err error) string {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlErrDeadlock:
+			return ErrorKindDeadlock
+		case mysqlErrLockWaitTimeout:
+			return ErrorKindLockWaitTimeout
+		case mysqlErrDuplicateKey:
+			return ErrorKindDuplicateKey
+		}
+		return ErrorKindMySQL
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorKindTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorKindCanceled
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn):
+		return ErrorKindBadConn
+	}
+
+	for _, gormErr := range gormErrors {
+		if errors.Is(err, gormErr) {
+			return ErrorKindGorm
+		}
+	}
+	return ErrorKindOther
+}
@@ -0,0 +1,197 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxNormalizedTables limits cached normalized table names, tables
+// over it are normalized every time.
+const maxNormalizedTables = 10000
+
+// labelName is the label of dropped label counter.
+const labelName = "label"
+
+// TableNormalizer return the table_name label value of a table, such as
+// "orders_*" of "orders_202610".
+type TableNormalizer func(table string) string
+
+// TableRule normalizes tables matched by regexp Pattern into Table. The
+// replacement syntax of regexp.Regexp.ReplaceAllString is supported, so
+// {Pattern: `^(\w+?)_\d+$`, Table: "${1}_*"} turns sharded tables into
+// one.
+type TableRule struct {
+	Pattern string
+	Table   string
+}
+
+// tableRule is a compiled TableRule.
+type tableRule struct {
+	re    *regexp.Regexp
+	table string
+}
+
+// LabelGuardConfig is the config of LabelGuard. DBName, Namespace and
+// NamePrefix name the dropped label counter like Config does.
+//
+// TableNormalizer and then TableRules normalize table names, the first
+// matched rule wins. MaxTables limits distinct table_name values after
+// normalization, and MaxLabelValues limits distinct values of each extra
+// label. Values over the limits become OverflowLabelValue, and 0 means
+// no limit.
+type LabelGuardConfig struct {
+	DBName     string
+	Namespace  string
+	NamePrefix string
+
+	TableNormalizer TableNormalizer
+	TableRules      []TableRule
+	MaxTables       int
+	MaxLabelValues  int
+}
+
+// LabelGuard guards cardinality of table_name and extra labels. It is a
+// prometheus collector of dropped label values. Share it between Config
+// of callbacks, so they have the same label values.
+type LabelGuard struct {
+	normalizer     TableNormalizer
+	rules          []tableRule
+	tables         *valueLimiter
+	maxLabelValues int
+
+	mu         sync.RWMutex
+	normalized map[string]string
+	labels     map[string]*valueLimiter
+
+	dropped *prometheus.CounterVec
+}
+
+// NewLabelGuard return a LabelGuard with c. It returns an error if a
+// pattern of TableRules is malformed.
+func NewLabelGuard(c LabelGuardConfig) (*LabelGuard, error) {
+	g := &LabelGuard{
+		normalizer:     c.TableNormalizer,
+		maxLabelValues: c.MaxLabelValues,
+		normalized:     map[string]string{},
+		labels:         map[string]*valueLimiter{},
+		dropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        fmt.Sprintf("%s_dropped_label_count", c.NamePrefix),
+				Namespace:   c.Namespace,
+				Help:        "gorm-plugin: label values dropped into other by label guard",
+				ConstLabels: getDBConstLabel(c.DBName),
+			},
+			[]string{labelName},
+		),
+	}
+
+	for _, rule := range c.TableRules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("query plugin: invalid table rule pattern %q: %w", rule.Pattern, err)
+		}
+		g.rules = append(g.rules, tableRule{re: re, table: rule.Table})
+	}
+	if c.MaxTables > 0 {
+		g.tables = newValueLimiter(c.MaxTables)
+	}
+
+	return g, nil
+}
+
+// table return the table_name label value of table. It is safe on nil.
+func (g *LabelGuard) table(table string) string {
+	if g == nil {
+		return table
+	}
+
+	table = g.normalize(table)
+	if g.tables == nil {
+		return table
+	}
+	v, ok := g.tables.limit(table)
+	if !ok {
+		g.dropped.WithLabelValues(labelTableName).Inc()
+	}
+	return v
+}
+
+// normalize return the normalized table, results are cached.
+func (g *LabelGuard) normalize(table string) string {
+	if g.normalizer == nil && len(g.rules) == 0 {
+		return table
+	}
+
+	g.mu.RLock()
+	normalized, ok := g.normalized[table]
+	g.mu.RUnlock()
+	if ok {
+		return normalized
+	}
+
+	normalized = table
+	if g.normalizer != nil {
+		normalized = g.normalizer(normalized)
+	}
+	for _, rule := range g.rules {
+		if rule.re.MatchString(normalized) {
+			normalized = rule.re.ReplaceAllString(normalized, rule.table)
+			break
+		}
+	}
+
+	g.mu.Lock()
+	if len(g.normalized) < maxNormalizedTables {
+		g.normalized[table] = normalized
+	}
+	g.mu.Unlock()
+	return normalized
+}
+
+// label return the value of extra label name. It is safe on nil.
+func (g *LabelGuard) label(name, value string) string {
+	if g == nil || g.maxLabelValues <= 0 {
+		return value
+	}
+
+	g.mu.RLock()
+	l, ok := g.labels[name]
+	g.mu.RUnlock()
+	if !ok {
+		g.mu.Lock()
+		if l, ok = g.labels[name]; !ok {
+			l = newValueLimiter(g.maxLabelValues)
+			g.labels[name] = l
+		}
+		g.mu.Unlock()
+	}
+
+	v, ok := l.limit(value)
+	if !ok {
+		g.dropped.WithLabelValues(name).Inc()
+	}
+	return v
+}
+
+// Describe implements prometheus.Collector
+func (g *LabelGuard) Describe(ch chan<- *prometheus.Desc) {
+	g.dropped.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (g *LabelGuard) Collect(ch chan<- prometheus.Metric) {
+	g.dropped.Collect(ch)
+}
+
+// reuse implements reusable, dropped label values are counted by the
+// existing LabelGuard.
+func (g *LabelGuard) reuse(existing prometheus.Collector) bool {
+	e, ok := existing.(*LabelGuard)
+	if ok {
+		g.dropped = e.dropped
+	}
+	return ok
+}
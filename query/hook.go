@@ -0,0 +1,113 @@
+package query
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Mode is the way to install interceptors into gorm callbacks.
+type Mode int
+
+const (
+	// ReplaceMode replaces gorm core callbacks with handlers wrapped
+	// by interceptors. It is the default mode.
+	ReplaceMode Mode = iota
+
+	// HookMode keeps gorm core callbacks untouched, and registers a
+	// before and an after callback around each of them. The start time
+	// is stored in Statement settings, so it still works when other
+	// plugins replace the core callbacks after us. Interceptors run in
+	// the after callback, and their next Handler does nothing.
+	HookMode
+)
+
+// hook names and setting keys
+const (
+	hookNamePrefix = "gorm-plugin:metric:"
+	startTimeKey   = "gorm-plugin:metric:start_time"
+)
+
+// hookSeq makes hook names unique between installations.
+var hookSeq uint64
+
+// StartTime return the time when the statement started. It is stored by
+// the before callback of HookMode, and it returns time.Now() if it is not
+// stored.
+func StartTime(db *gorm.DB) time.Time {
+	if v, ok := db.Statement.Settings.Load(startTimeKey); ok {
+		if start, ok := v.(time.Time); ok {
+			return start
+		}
+	}
+	return time.Now()
+}
+
+// setStartTime store current time as the statement start time.
+func setStartTime(db *gorm.DB) {
+	db.Statement.Settings.Store(startTimeKey, time.Now())
+}
+
+// registerFunc is a function to register a named gorm callback.
+type registerFunc func(name string, fn func(*gorm.DB)) error
+
+// removeFunc is a function to remove a named gorm callback.
+type removeFunc func(name string) error
+
+// hookHandler is implemented by metricCallback which needs its own
+// before and after handlers in HookMode, instead of running the
+// interceptor in the after callback.
+type hookHandler interface {
+	getHookHandlers(cb string) (before, after Handler)
+}
+
+// getHookHandlers return before and after handlers of m for cb. By
+// default, the before handler stores start time, and the after handler
+// runs the interceptor.
+func getHookHandlers(m metricCallback, cb string) (before, after Handler) {
+	if h, ok := m.(hookHandler); ok {
+		return h.getHookHandlers(cb)
+	}
+	return setStartTime, m.getInterceptor()(cb)(func(*gorm.DB) {})
+}
+
+// registerHooks register a before and an after callback of m for cb of
+// p, it returns an error if cb is not found. The returned restoreFunc
+// removes both of them.
+func registerHooks(m metricCallback, p processor, cb string, before, after registerFunc, remove removeFunc) (restoreFunc, error) {
+	if _, err := getCallback(p, cb); err != nil {
+		return nil, err
+	}
+	beforeHandler, afterHandler := getHookHandlers(m, cb)
+
+	id := atomic.AddUint64(&hookSeq, 1)
+	beforeName := fmt.Sprintf("%s%d:before:%s", hookNamePrefix, id, cb)
+	handler := func(db *gorm.DB) {
+		resetStatementSettings(db)
+		beforeHandler(db)
+	}
+	if err := before(beforeName, handler); err != nil {
+		return nil, fmt.Errorf("query plugin: register callback %s failed: %w", beforeName, err)
+	}
+	restores := []restoreFunc{removeHook(beforeName, remove)}
+
+	afterName := fmt.Sprintf("%s%d:after:%s", hookNamePrefix, id, cb)
+	if err := after(afterName, afterHandler); err != nil {
+		return restoreAll(restores), fmt.Errorf("query plugin: register callback %s failed: %w", afterName, err)
+	}
+	restores = append(restores, removeHook(afterName, remove))
+
+	return restoreAll(restores), nil
+}
+
+// removeHook return a restoreFunc removing callback name.
+func removeHook(name string, remove removeFunc) restoreFunc {
+	return func() error {
+		if err := remove(name); err != nil {
+			return fmt.Errorf("query plugin: remove callback %s failed: %w", name, err)
+		}
+		return nil
+	}
+}
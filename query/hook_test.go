@@ -0,0 +1,61 @@
+package query
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+)
+
+func TestHookModeKeepsReplacedCallback(t *testing.T) {
+	db := newDryRunDB(t)
+	c := Config{NamePrefix: "gorm", SlowThreshold: time.Millisecond, Mode: HookMode}
+	plugin := New(SlowQueryCallback(c), ErrorQueryCallback(c))
+	if err := db.Use(plugin); err != nil {
+		t.Fatal(err)
+	}
+
+	// another plugin replaces gorm:query after us.
+	errReplaced := errors.New("replaced query failed")
+	err := db.Callback().Query().Replace("gorm:query", func(db *gorm.DB) {
+		callbacks.Query(db)
+		time.Sleep(2 * time.Millisecond)
+		db.AddError(errReplaced)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Table("users").Find(&[]rowsModel{}).Error; err != errReplaced {
+		t.Fatalf("error = %v, want the error of the replaced callback", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(plugin.MetricsCollectors()...)
+	if v, _ := gatherValue(t, registry, "gorm_slow_query_count"); v != 1 {
+		t.Errorf("slow queries = %v, want 1", v)
+	}
+	if v, _ := gatherValue(t, registry, "gorm_error_count"); v != 1 {
+		t.Errorf("error queries = %v, want 1", v)
+	}
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "gorm_query_time" {
+			continue
+		}
+		h := mf.Metric[0].GetHistogram()
+		if h.GetSampleCount() != 1 || h.GetSampleSum() < (2*time.Millisecond).Seconds() {
+			t.Errorf("query time count = %d, sum = %v, want 1 and the time of the replaced callback",
+				h.GetSampleCount(), h.GetSampleSum())
+		}
+		return
+	}
+	t.Error("query time not found")
+}
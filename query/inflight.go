@@ -0,0 +1,202 @@
+package query
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+// inFlightKey is the setting key of in flight gauge in HookMode.
+const inFlightKey = "gorm-plugin:metric:in_flight"
+
+// inFlightGauge counts queries in progress, and the peak of them.
+type inFlightGauge struct {
+	current int64
+	peak    int64
+}
+
+// inc increase in flight queries by 1, and update the peak.
+func (g *inFlightGauge) inc() {
+	n := atomic.AddInt64(&g.current, 1)
+	for {
+		peak := atomic.LoadInt64(&g.peak)
+		if n <= peak || atomic.CompareAndSwapInt64(&g.peak, peak, n) {
+			return
+		}
+	}
+}
+
+// dec decrease in flight queries by 1.
+func (g *inFlightGauge) dec() {
+	atomic.AddInt64(&g.current, -1)
+}
+
+// inFlightMetric is a prometheus collector of in flight queries and
+// peak concurrency by table and callback. The peak is reset to the
+// current value after every scrape.
+type inFlightMetric struct {
+	current *prometheus.Desc
+	peak    *prometheus.Desc
+	labeler *labeler
+	gauges  *inFlightGauges
+}
+
+// inFlightGauges are in flight gauges by metric key.
+type inFlightGauges struct {
+	mu sync.RWMutex
+	m  map[metricKey]*inFlightGauge
+}
+
+// newInFlightMetric return a inFlightMetric
+func newInFlightMetric(namePrefix, namespace, dbName string, lb *labeler) *inFlightMetric {
+	labels := lb.labels(labelTableName, labelCallbackName)
+	return &inFlightMetric{
+		labeler: lb,
+		current: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", fmt.Sprintf("%s_in_flight_queries", namePrefix)),
+			"gorm-plugin: in flight queries gauge",
+			labels, getDBConstLabel(dbName),
+		),
+		peak: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", fmt.Sprintf("%s_in_flight_queries_peak", namePrefix)),
+			"gorm-plugin: peak of in flight queries since last scrape",
+			labels, getDBConstLabel(dbName),
+		),
+		gauges: &inFlightGauges{m: map[metricKey]*inFlightGauge{}},
+	}
+}
+
+// gauge return the inFlightGauge of statement of db and callback.
+func (m *inFlightMetric) gauge(db *gorm.DB, cbName string) *inFlightGauge {
+	key := m.labeler.key(db, cbName)
+	gauges := m.gauges
+	gauges.mu.RLock()
+	g, ok := gauges.m[key]
+	gauges.mu.RUnlock()
+	if ok {
+		return g
+	}
+
+	gauges.mu.Lock()
+	defer gauges.mu.Unlock()
+	if g, ok = gauges.m[key]; !ok {
+		g = &inFlightGauge{}
+		gauges.m[key] = g
+	}
+	return g
+}
+
+// Describe implements prometheus.Collector
+func (m *inFlightMetric) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.current
+	ch <- m.peak
+}
+
+// Collect implements prometheus.Collector
+func (m *inFlightMetric) Collect(ch chan<- prometheus.Metric) {
+	gauges := m.gauges
+	gauges.mu.RLock()
+	defer gauges.mu.RUnlock()
+
+	for key, g := range gauges.m {
+		current := atomic.LoadInt64(&g.current)
+		peak := atomic.SwapInt64(&g.peak, current)
+		if peak < current {
+			peak = current
+		}
+
+		lvs := []string{key.table, key.callback}
+		if n := m.labeler.len(); n > 0 {
+			lvs = append(lvs, splitExtraValues(key.extra, n)...)
+		}
+		ch <- prometheus.MustNewConstMetric(m.current, prometheus.GaugeValue, float64(current), lvs...)
+		ch <- prometheus.MustNewConstMetric(m.peak, prometheus.GaugeValue, float64(peak), lvs...)
+	}
+}
+
+// reuse implements reusable, in flight queries are counted by gauges of
+// the existing inFlightMetric.
+func (m *inFlightMetric) reuse(existing prometheus.Collector) bool {
+	e, ok := existing.(*inFlightMetric)
+	if ok {
+		m.gauges = e.gauges
+	}
+	return ok
+}
+
+// inFlightMetricInterceptor return a in flight query Interceptor.
+func inFlightMetricInterceptor(runtime *Runtime, metric *inFlightMetric) Interceptor {
+	return func(cbName string) func(next Handler) Handler {
+		return func(originHandler Handler) Handler {
+			return func(db *gorm.DB) {
+				if !recordable(runtime.state(), statementSettings(db), metric.labeler.table(db)) {
+					originHandler(db)
+					return
+				}
+
+				g := metric.gauge(db, cbName)
+				g.inc()
+				defer g.dec()
+				originHandler(db)
+			}
+		}
+	}
+}
+
+// inFlightCallback is for replace callback with in flight metrics.
+type inFlightCallback struct {
+	db          *gorm.DB
+	runtime     *Runtime
+	metric      *inFlightMetric
+	interceptor Interceptor
+	mode        Mode
+}
+
+// newInFlightCallback return a inFlightCallback.
+func newInFlightCallback(db *gorm.DB, runtime *Runtime, metric *inFlightMetric, mode Mode) metricCallback {
+	return &inFlightCallback{
+		db:          db,
+		runtime:     runtime,
+		metric:      metric,
+		interceptor: inFlightMetricInterceptor(runtime, metric),
+		mode:        mode,
+	}
+}
+
+func (i *inFlightCallback) getDb() *gorm.DB {
+	return i.db
+}
+
+func (i *inFlightCallback) getInterceptor() Interceptor {
+	return i.interceptor
+}
+
+func (i *inFlightCallback) getMode() Mode {
+	return i.mode
+}
+
+// getHookHandlers implements hookHandler. The before handler increases
+// the gauge and stores it in Statement settings, the after handler
+// decreases it.
+func (i *inFlightCallback) getHookHandlers(cb string) (before, after Handler) {
+	key := fmt.Sprintf("%s:%p:%s", inFlightKey, i.metric, cb)
+	before = func(db *gorm.DB) {
+		if !recordable(i.runtime.state(), statementSettings(db), i.metric.labeler.table(db)) {
+			return
+		}
+
+		g := i.metric.gauge(db, cb)
+		g.inc()
+		db.Statement.Settings.Store(key, g)
+	}
+	after = func(db *gorm.DB) {
+		if v, ok := db.Statement.Settings.Load(key); ok {
+			db.Statement.Settings.Delete(key)
+			v.(*inFlightGauge).dec()
+		}
+	}
+	return before, after
+}
@@ -0,0 +1,125 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// LabelExtractor return extra label values of a statement from its
+// context, such as tenant or route. Labels missing in the returned
+// map are empty.
+type LabelExtractor func(ctx context.Context) map[string]string
+
+// extraLabelSep separates extra label values in metricKey. It is not
+// valid UTF-8, so it never shows up in a valid label value.
+const extraLabelSep = "\xff"
+
+// labelNameRE is the prometheus label name pattern.
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// labeler builds label values of statements. Table names are parsed
+// by tables and guarded by guard, and extra labels are declared in
+// Config with the extractor of their values.
+type labeler struct {
+	names     []string
+	extractor LabelExtractor
+	guard     *LabelGuard
+	tables    *tableParser
+}
+
+// newLabeler return a labeler of extra label names. It returns an error
+// if a name is invalid, reserved, or duplicated.
+func newLabeler(names []string, extractor LabelExtractor, guard *LabelGuard, tables *tableParser) (*labeler, error) {
+	seen := map[string]bool{
+		labelDbName:       true,
+		labelTableName:    true,
+		labelCallbackName: true,
+		labelErrorKind:    true,
+		labelDigest:       true,
+		labelQueryName:    true,
+	}
+	for _, name := range names {
+		if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("query plugin: invalid extra label %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("query plugin: extra label %q is reserved or duplicated", name)
+		}
+		seen[name] = true
+	}
+
+	return &labeler{names: names, extractor: extractor, guard: guard, tables: tables}, nil
+}
+
+// len return the number of extra labels, it is safe on nil.
+func (l *labeler) len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.names)
+}
+
+// labels return labels with extra label names appended.
+func (l *labeler) labels(labels ...string) []string {
+	if l.len() == 0 {
+		return labels
+	}
+	return append(labels, l.names...)
+}
+
+// table return the table of the statement of db, it is parsed from SQL
+// if Statement.Table is empty and tables is set. It is safe on nil.
+func (l *labeler) table(db *gorm.DB) string {
+	if l == nil {
+		return db.Statement.Table
+	}
+	return l.tables.table(db)
+}
+
+// key return metricKey of the statement of db and callback cbName, it
+// is safe on nil.
+func (l *labeler) key(db *gorm.DB, cbName string) metricKey {
+	if l == nil {
+		return metricKey{table: db.Statement.Table, callback: cbName}
+	}
+	return metricKey{
+		table:    l.guard.table(l.table(db)),
+		callback: cbName,
+		extra:    l.values(db.Statement.Context),
+	}
+}
+
+// values return extra label values of ctx joined by extraLabelSep.
+// It is empty if there is no extra label or extractor.
+func (l *labeler) values(ctx context.Context) string {
+	if len(l.names) == 0 || l.extractor == nil {
+		return ""
+	}
+
+	m := l.extractor(ctx)
+	if len(m) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for i, name := range l.names {
+		if i > 0 {
+			b.WriteString(extraLabelSep)
+		}
+		b.WriteString(l.guard.label(name, m[name]))
+	}
+	return b.String()
+}
+
+// splitExtraValues split extra label values of metricKey into n values.
+func splitExtraValues(extra string, n int) []string {
+	values := make([]string, n)
+	if extra != "" {
+		copy(values, strings.SplitN(extra, extraLabelSep, n))
+	}
+	return values
+}
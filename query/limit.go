@@ -0,0 +1,67 @@
+package query
+
+import "sync"
+
+// label values
+const (
+	// UnnamedQuery is the query_name label value of statements without
+	// settings.QueryName.
+	UnnamedQuery = "unnamed"
+	// OverflowLabelValue is the label value of values over the limit.
+	OverflowLabelValue = "other"
+)
+
+// DefaultMaxQueryNames is the default limit of distinct query names.
+const DefaultMaxQueryNames = 100
+
+// QueryNames limits distinct query names. Share it between Config of
+// callbacks, so slow query counter, query time histogram and error
+// counter allow the same query names when the limit is reached.
+type QueryNames struct {
+	limiter *valueLimiter
+}
+
+// NewQueryNames return a QueryNames allowing max distinct names, default
+// is DefaultMaxQueryNames.
+func NewQueryNames(max int) *QueryNames {
+	if max <= 0 {
+		max = DefaultMaxQueryNames
+	}
+	return &QueryNames{limiter: newValueLimiter(max)}
+}
+
+// valueLimiter limits distinct values of a label, values over the limit
+// become OverflowLabelValue.
+type valueLimiter struct {
+	max int
+
+	mu     sync.RWMutex
+	values map[string]struct{}
+}
+
+// newValueLimiter return a valueLimiter allowing max distinct values.
+func newValueLimiter(max int) *valueLimiter {
+	return &valueLimiter{max: max, values: map[string]struct{}{}}
+}
+
+// limit return v and true if it is allowed, or OverflowLabelValue
+// and false.
+func (l *valueLimiter) limit(v string) (string, bool) {
+	l.mu.RLock()
+	_, ok := l.values[v]
+	l.mu.RUnlock()
+	if ok {
+		return v, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok = l.values[v]; ok {
+		return v, true
+	}
+	if len(l.values) >= l.max {
+		return OverflowLabelValue, false
+	}
+	l.values[v] = struct{}{}
+	return v, true
+}
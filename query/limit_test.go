@@ -0,0 +1,39 @@
+package query
+
+import "testing"
+
+func TestValueLimiter(t *testing.T) {
+	l := newValueLimiter(2)
+	for _, c := range []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{"a", "a", true},
+		{"b", "b", true},
+		{"c", OverflowLabelValue, false},
+		{"a", "a", true},
+	} {
+		if got, ok := l.limit(c.value); got != c.want || ok != c.ok {
+			t.Errorf("limit(%q) = %q, %v, want %q, %v", c.value, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestSharedQueryNames(t *testing.T) {
+	c := Config{QueryNameLabel: true, QueryNames: NewQueryNames(1)}
+	slow, errs := c.queryNameLimiter(), c.queryNameLimiter()
+	if slow != errs {
+		t.Fatal("callbacks sharing QueryNames have different limiters")
+	}
+
+	slow.limit("a")
+	if got := limitQueryName(errs, "b"); got != OverflowLabelValue {
+		t.Errorf("name over the shared limit = %q, want %q", got, OverflowLabelValue)
+	}
+
+	c.QueryNames = nil
+	if c.queryNameLimiter() == c.queryNameLimiter() {
+		t.Error("callbacks without QueryNames share a limiter")
+	}
+}
@@ -0,0 +1,196 @@
+package query
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultLongTxCheckInterval is the default interval of checking open
+// transactions.
+const DefaultLongTxCheckInterval = time.Second
+
+// LongTx is a transaction which is open over the threshold. Stack is the
+// stack of the goroutine beginning it, and Statements are the statements
+// executed in it so far.
+type LongTx struct {
+	Start      time.Time
+	Elapsed    time.Duration
+	Statements int64
+	Stack      string
+}
+
+// LongTxHook is called once for every transaction open over the
+// threshold. It is called by the goroutine checking transactions, so it
+// should not block.
+type LongTxHook func(tx LongTx)
+
+// LongTxOption is for detecting transactions open over Threshold. They
+// are checked every Interval, default is DefaultLongTxCheckInterval.
+// Now and Tick replace the clock and the ticker if they are set, such as
+// a fake clock in tests. Every value sent to Tick runs a check.
+type LongTxOption struct {
+	Threshold time.Duration
+	Interval  time.Duration
+	Hook      LongTxHook
+
+	Now  func() time.Time
+	Tick <-chan time.Time
+}
+
+// check return error if o is invalid.
+func (o *LongTxOption) check() error {
+	if o == nil {
+		return nil
+	}
+	if o.Threshold <= 0 {
+		return fmt.Errorf("query plugin: long transaction threshold must be positive")
+	}
+	if o.Hook == nil {
+		return fmt.Errorf("query plugin: long transaction hook is nil")
+	}
+	return nil
+}
+
+// now return the clock of o, default is time.Now.
+func (o *LongTxOption) now() func() time.Time {
+	if o == nil || o.Now == nil {
+		return time.Now
+	}
+	return o.Now
+}
+
+// openTx is an open transaction in the registry.
+type openTx struct {
+	tx       *txConn
+	stack    string
+	reported bool
+}
+
+// longTxDetector is the registry of open transactions, keyed by the
+// ConnPool of transactions. It calls the hook for transactions open over
+// the threshold.
+type longTxDetector struct {
+	opt     LongTxOption
+	runtime *Runtime
+
+	mu   sync.Mutex
+	open map[gorm.ConnPool]*openTx
+}
+
+// newLongTxDetector return a longTxDetector of opt, it returns nil if
+// opt is nil.
+func newLongTxDetector(opt *LongTxOption, runtime *Runtime) *longTxDetector {
+	if opt == nil {
+		return nil
+	}
+
+	d := &longTxDetector{
+		opt:     *opt,
+		runtime: runtime,
+		open:    map[gorm.ConnPool]*openTx{},
+	}
+	d.opt.Now = opt.now()
+	if d.opt.Interval <= 0 {
+		d.opt.Interval = DefaultLongTxCheckInterval
+	}
+	return d
+}
+
+// add registers tx with the stack beginning it.
+func (d *longTxDetector) add(tx *txConn) {
+	if d == nil {
+		return
+	}
+
+	entry := &openTx{tx: tx, stack: string(debug.Stack())}
+	d.mu.Lock()
+	d.open[tx] = entry
+	d.mu.Unlock()
+}
+
+// remove unregisters tx.
+func (d *longTxDetector) remove(tx *txConn) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	delete(d.open, tx)
+	d.mu.Unlock()
+}
+
+// check calls the hook for transactions open over the threshold at now,
+// which are not reported yet. The hook is called without the lock, so
+// it can begin or end transactions.
+func (d *longTxDetector) check(now time.Time) {
+	if d.runtime.state().config.Disabled {
+		return
+	}
+
+	var long []LongTx
+
+	d.mu.Lock()
+	for _, entry := range d.open {
+		elapsed := now.Sub(entry.tx.start)
+		if entry.reported || elapsed < d.opt.Threshold {
+			continue
+		}
+		entry.reported = true
+		long = append(long, LongTx{
+			Start:      entry.tx.start,
+			Elapsed:    elapsed,
+			Statements: atomic.LoadInt64(&entry.tx.statements),
+			Stack:      entry.stack,
+		})
+	}
+	d.mu.Unlock()
+
+	for _, tx := range long {
+		d.opt.Hook(tx)
+	}
+}
+
+// start runs checks in background, on every tick of the ticker or the
+// Tick of option. The returned function stops it.
+func (d *longTxDetector) start() func() {
+	if d == nil {
+		return func() {}
+	}
+
+	tick := d.opt.Tick
+	var ticker *time.Ticker
+	if tick == nil {
+		ticker = time.NewTicker(d.opt.Interval)
+		tick = ticker.C
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case _, ok := <-tick:
+				if !ok {
+					return
+				}
+				d.check(d.opt.Now())
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if ticker != nil {
+				ticker.Stop()
+			}
+			close(done)
+		})
+	}
+}
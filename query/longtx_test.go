@@ -0,0 +1,122 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fakePool is a ConnPool beginning fakeTx, statements do nothing.
+type fakePool struct {
+	gorm.ConnPool
+}
+
+func (fakePool) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return nil, nil
+}
+
+func (fakePool) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) {
+	return fakeTx{}, nil
+}
+
+// fakeTx is a transaction of fakePool.
+type fakeTx struct {
+	fakePool
+}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+// fakeClock is a clock moved by tests.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestLongTxHook(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	tick := make(chan time.Time)
+	hooked := make(chan LongTx, 10)
+	cb := TransactionCallback(Config{NamePrefix: "gorm", LongTx: &LongTxOption{
+		Threshold: 10 * time.Second,
+		Hook:      func(tx LongTx) { hooked <- tx },
+		Now:       clock.Now,
+		Tick:      tick,
+	}})
+
+	db := &gorm.DB{Config: &gorm.Config{}, Statement: &gorm.Statement{ConnPool: fakePool{}}}
+	restore, err := cb.apply(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restore()
+
+	// check sends two ticks, the first check is done when the second
+	// tick is received.
+	check := func() {
+		tick <- time.Time{}
+		tick <- time.Time{}
+	}
+
+	beginner := db.Statement.ConnPool.(gorm.ConnPoolBeginner)
+	long, err := beginner.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		long.ExecContext(context.Background(), "UPDATE users SET name = ?")
+	}
+
+	clock.add(5 * time.Second)
+	short, err := beginner.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+	if len(hooked) != 0 {
+		t.Fatalf("hook is called %d times before threshold", len(hooked))
+	}
+
+	clock.add(5 * time.Second)
+	if err := short.(gorm.TxCommitter).Commit(); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	check()
+	if len(hooked) != 1 {
+		t.Fatalf("hook is called %d times, want 1", len(hooked))
+	}
+	tx := <-hooked
+	if tx.Elapsed != 10*time.Second || tx.Statements != 3 || !tx.Start.Equal(time.Unix(0, 0)) {
+		t.Errorf("long transaction = %+v, want 10s elapsed and 3 statements", tx)
+	}
+	if tx.Stack == "" {
+		t.Error("stack of long transaction is empty")
+	}
+
+	// the committed one is not reported, and the long one is reported once.
+	clock.add(time.Minute)
+	if err := long.(gorm.TxCommitter).Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	if len(hooked) != 0 {
+		t.Fatalf("hook is called %d more times", len(hooked))
+	}
+}
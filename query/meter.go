@@ -0,0 +1,137 @@
+package query
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricOpts are options of a metric. Labels are the variable labels,
+// and Unit is used by meters which support it, such as OpenTelemetry.
+type MetricOpts struct {
+	Namespace   string
+	Name        string
+	Help        string
+	Unit        string
+	ConstLabels map[string]string
+	Labels      []string
+}
+
+// HistogramOpts are options of a histogram. Native histogram options
+// are used by meters which support it, such as prometheus.
+type HistogramOpts struct {
+	MetricOpts
+	Buckets                        []float64
+	NativeHistogramBucketFactor    float64
+	NativeHistogramMaxBucketNumber uint32
+}
+
+// Meter creates metrics of query plugin, such as query time histogram,
+// slow query counter and error counter. PrometheusMeter is the default
+// one. Metrics which implement prometheus.Collector are returned by
+// MetricsCollectors.
+type Meter interface {
+	NewCounter(opts MetricOpts) CounterVec
+	NewHistogram(opts HistogramOpts) HistogramVec
+}
+
+// CounterVec is a counter with variable labels.
+type CounterVec interface {
+	// WithLabelValues return the counter of label values, they are in
+	// order of MetricOpts.Labels.
+	WithLabelValues(lvs ...string) Counter
+}
+
+// Counter is a counter of a set of label values.
+type Counter interface {
+	Inc()
+}
+
+// HistogramVec is a histogram with variable labels.
+type HistogramVec interface {
+	// WithLabelValues return the observer of label values, they are in
+	// order of MetricOpts.Labels.
+	WithLabelValues(lvs ...string) Observer
+}
+
+// Observer observes values of a histogram of a set of label values.
+type Observer interface {
+	Observe(float64)
+}
+
+// PrometheusMeter creates prometheus metrics, they are collectors.
+type PrometheusMeter struct{}
+
+// NewCounter implements Meter
+func (PrometheusMeter) NewCounter(opts MetricOpts) CounterVec {
+	return &promCounterVec{prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        opts.Name,
+			Namespace:   opts.Namespace,
+			Help:        opts.Help,
+			ConstLabels: opts.ConstLabels,
+		},
+		opts.Labels,
+	)}
+}
+
+// NewHistogram implements Meter
+func (PrometheusMeter) NewHistogram(opts HistogramOpts) HistogramVec {
+	return &promHistogramVec{prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:        opts.Name,
+			Namespace:   opts.Namespace,
+			Help:        opts.Help,
+			Buckets:     opts.Buckets,
+			ConstLabels: opts.ConstLabels,
+
+			NativeHistogramBucketFactor:    opts.NativeHistogramBucketFactor,
+			NativeHistogramMaxBucketNumber: opts.NativeHistogramMaxBucketNumber,
+		},
+		opts.Labels,
+	)}
+}
+
+// promCounterVec is a CounterVec and a prometheus.Collector
+type promCounterVec struct {
+	*prometheus.CounterVec
+}
+
+// WithLabelValues implements CounterVec
+func (v *promCounterVec) WithLabelValues(lvs ...string) Counter {
+	return v.CounterVec.WithLabelValues(lvs...)
+}
+
+// reuse implements reusable
+func (v *promCounterVec) reuse(existing prometheus.Collector) bool {
+	switch e := existing.(type) {
+	case *promCounterVec:
+		v.CounterVec = e.CounterVec
+	case *prometheus.CounterVec:
+		v.CounterVec = e
+	default:
+		return false
+	}
+	return true
+}
+
+// promHistogramVec is a HistogramVec and a prometheus.Collector
+type promHistogramVec struct {
+	*prometheus.HistogramVec
+}
+
+// WithLabelValues implements HistogramVec
+func (v *promHistogramVec) WithLabelValues(lvs ...string) Observer {
+	return v.HistogramVec.WithLabelValues(lvs...)
+}
+
+// reuse implements reusable
+func (v *promHistogramVec) reuse(existing prometheus.Collector) bool {
+	switch e := existing.(type) {
+	case *promHistogramVec:
+		v.HistogramVec = e.HistogramVec
+	case *prometheus.HistogramVec:
+		v.HistogramVec = e
+	default:
+		return false
+	}
+	return true
+}
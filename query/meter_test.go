@@ -0,0 +1,123 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+type meterUser struct {
+	ID   int64
+	Name string
+}
+
+// recordWithMeter records a slow query and an error query with meter, and
+// return the plugin.
+func recordWithMeter(t *testing.T, meter Meter) MetricPlugin {
+	db := newDryRunDB(t)
+	c := Config{DBName: "db", Namespace: "test", NamePrefix: "gorm", SlowThreshold: time.Nanosecond, Meter: meter}
+	plugin := New(SlowQueryCallback(c), ErrorQueryCallback(c))
+	if err := db.Use(plugin); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Find(&[]meterUser{})
+	db.Model(&meterUser{}).Update("name", "bob") // WHERE conditions required
+	return plugin
+}
+
+// series return "name{label=value,...}" of all series sorted.
+func series(name string, labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("%s{%s}", name, strings.Join(pairs, ","))
+}
+
+func TestMetersHaveSameSeries(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(recordWithMeter(t, PrometheusMeter{}).MetricsCollectors()...)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var promSeries []string
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			labels := map[string]string{}
+			for _, l := range m.Label {
+				labels[l.GetName()] = l.GetValue()
+			}
+			promSeries = append(promSeries, series(mf.GetName(), labels))
+		}
+	}
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	if cols := recordWithMeter(t, NewOTelMeter(provider.Meter("test"))).MetricsCollectors(); len(cols) != 0 {
+		t.Errorf("otel meter has %d prometheus collectors, want 0", len(cols))
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	var otelSeries []string
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			var attrs []map[string]string
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					attrs = append(attrs, attributeMap(dp.Attributes.ToSlice()))
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					attrs = append(attrs, attributeMap(dp.Attributes.ToSlice()))
+				}
+			default:
+				t.Fatalf("unexpected data %T of %s", m.Data, m.Name)
+			}
+			for _, labels := range attrs {
+				otelSeries = append(otelSeries, series(m.Name, labels))
+			}
+		}
+	}
+
+	sort.Strings(promSeries)
+	sort.Strings(otelSeries)
+	want := []string{
+		"test_gorm_error_count{callback=gorm:update,db_name=db,table_name=meter_users}",
+		"test_gorm_query_time{db_name=db,table_name=meter_users}",
+		"test_gorm_slow_query_count{callback=gorm:query,db_name=db,table_name=meter_users}",
+		"test_gorm_slow_query_count{callback=gorm:update,db_name=db,table_name=meter_users}",
+	}
+	if !reflect.DeepEqual(promSeries, want) {
+		t.Errorf("prometheus series = %q, want %q", promSeries, want)
+	}
+	if !reflect.DeepEqual(otelSeries, want) {
+		t.Errorf("otel series = %q, want %q", otelSeries, want)
+	}
+}
+
+// attributeMap return attributes by key.
+func attributeMap(kvs []attribute.KeyValue) map[string]string {
+	m := map[string]string{}
+	for _, kv := range kvs {
+		m[string(kv.Key)] = kv.Value.Emit()
+	}
+	return m
+}
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/changsongl/gorm-plugin/fingerprint"
 )
 
 // metric labels key
@@ -12,88 +12,228 @@ const (
 	labelDbName       = "db_name"
 	labelTableName    = "table_name"
 	labelCallbackName = "callback"
+	labelErrorKind    = "error_kind"
+	labelDigest       = "digest"
+	labelQueryName    = "query_name"
 )
 
+// DefaultBuckets is the default buckets of query time histogram (unit: second).
+var DefaultBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultRowsBuckets is the default buckets of rows histograms.
+var DefaultRowsBuckets = []float64{1, 5, 10, 50, 100, 500, 1000, 5000, 10000, 50000, 100000}
+
+// slowMetricConfig is for slow query counter and query time histogram.
+type slowMetricConfig struct {
+	digestLabel           bool
+	callbackLabel         bool
+	queryNames            *valueLimiter
+	fingerprintOpts       []fingerprint.Option
+	labeler               *labeler
+	buckets               []float64
+	nativeBucketFactor    float64
+	nativeMaxBucketNumber uint32
+}
+
 // slowMetric has time histogram and slow query counter.
 type slowMetric struct {
-	counter   *prometheus.CounterVec
-	histogram *prometheus.HistogramVec
+	counter       CounterVec
+	histogram     HistogramVec
+	callbackLabel bool
+	digestLabel   bool
+	queryNames    *valueLimiter
+	labeler       *labeler
+
+	fingerprintOpts []fingerprint.Option
+
+	counters  *counterCache
+	observers *observerCache
 }
 
 // errorMetric has error counter.
 type errorMetric struct {
-	counter *prometheus.CounterVec
+	counter    CounterVec
+	counters   *counterCache
+	classifier ErrorClassifier
+	queryNames *valueLimiter
+	labeler    *labeler
+}
+
+// rowsMetric has rows affected and rows returned histograms.
+type rowsMetric struct {
+	affected HistogramVec
+	returned HistogramVec
+
+	labeler           *labeler
+	affectedObservers *observerCache
+	returnedObservers *observerCache
 }
 
 // newSlowMetric return a slowMetric
-func newSlowMetric(namePrefix, namespace, dbName string) *slowMetric {
+func newSlowMetric(meter Meter, namePrefix, namespace, dbName string, hc slowMetricConfig) *slowMetric {
+	histogramLabels := []string{labelTableName}
+	if hc.callbackLabel {
+		histogramLabels = append(histogramLabels, labelCallbackName)
+	}
+
+	counterLabels := []string{labelTableName, labelCallbackName}
+	if hc.digestLabel {
+		counterLabels = append(counterLabels, labelDigest)
+	}
+
+	if hc.queryNames != nil {
+		histogramLabels = append(histogramLabels, labelQueryName)
+		counterLabels = append(counterLabels, labelQueryName)
+	}
+	histogramLabels = hc.labeler.labels(histogramLabels...)
+	counterLabels = hc.labeler.labels(counterLabels...)
+
 	slowCounter := slowMetric{
-		counter: prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Name:        fmt.Sprintf("%s_slow_query_count", namePrefix),
-				Namespace:   namespace,
-				Help:        "gorm-plugin: slow query counter",
-				ConstLabels: getDBConstLabel(dbName),
-			},
-			[]string{labelTableName, labelCallbackName},
-		),
-		histogram: prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
+		callbackLabel: hc.callbackLabel,
+		digestLabel:   hc.digestLabel,
+		queryNames:    hc.queryNames,
+		labeler:       hc.labeler,
+
+		fingerprintOpts: hc.fingerprintOpts,
+		counter: meter.NewCounter(MetricOpts{
+			Name:        fmt.Sprintf("%s_slow_query_count", namePrefix),
+			Namespace:   namespace,
+			Help:        "gorm-plugin: slow query counter",
+			ConstLabels: getDBConstLabel(dbName),
+			Labels:      counterLabels,
+		}),
+		histogram: meter.NewHistogram(HistogramOpts{
+			MetricOpts: MetricOpts{
 				Name:        fmt.Sprintf("%s_query_time", namePrefix),
 				Namespace:   namespace,
 				Help:        "gorm-plugin: slow query timeQuery histogram (unit: second)",
-				Buckets:     []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+				Unit:        "s",
 				ConstLabels: getDBConstLabel(dbName),
+				Labels:      histogramLabels,
 			},
-			[]string{labelTableName},
-		),
+			Buckets: hc.buckets,
+
+			NativeHistogramBucketFactor:    hc.nativeBucketFactor,
+			NativeHistogramMaxBucketNumber: hc.nativeMaxBucketNumber,
+		}),
 	}
+	slowCounter.counters = newCounterCache(slowCounter.counter, counterLabels, hc.labeler.len())
+	slowCounter.observers = newObserverCache(slowCounter.histogram, histogramLabels, hc.labeler.len())
 
 	return &slowCounter
 }
 
-// newErrorMetric return a errorMetric
-func newErrorMetric(namePrefix, namespace, dbName string) *errorMetric {
+// newErrorMetric return a errorMetric. It has error_kind label
+// if classifier is not nil, and query_name label if queryNames
+// is not nil, and extra labels of lb at last.
+func newErrorMetric(meter Meter, namePrefix, namespace, dbName string, classifier ErrorClassifier, queryNames *valueLimiter, lb *labeler) *errorMetric {
+	labels := []string{labelTableName, labelCallbackName}
+	if classifier != nil {
+		labels = append(labels, labelErrorKind)
+	}
+	if queryNames != nil {
+		labels = append(labels, labelQueryName)
+	}
+	labels = lb.labels(labels...)
+
 	errorCounter := errorMetric{
-		counter: prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Name:        fmt.Sprintf("%s_error_count", namePrefix),
+		classifier: classifier,
+		queryNames: queryNames,
+		labeler:    lb,
+		counter: meter.NewCounter(MetricOpts{
+			Name:        fmt.Sprintf("%s_error_count", namePrefix),
+			Namespace:   namespace,
+			Help:        "gorm-plugin: error counter",
+			ConstLabels: getDBConstLabel(dbName),
+			Labels:      labels,
+		}),
+	}
+	errorCounter.counters = newCounterCache(errorCounter.counter, labels, lb.len())
+
+	return &errorCounter
+}
+
+// newRowsMetric return a rowsMetric
+func newRowsMetric(meter Meter, namePrefix, namespace, dbName string, buckets []float64, lb *labeler) *rowsMetric {
+	labels := lb.labels(labelTableName, labelCallbackName)
+	rows := rowsMetric{
+		labeler: lb,
+		affected: meter.NewHistogram(HistogramOpts{
+			MetricOpts: MetricOpts{
+				Name:        fmt.Sprintf("%s_rows_affected", namePrefix),
+				Namespace:   namespace,
+				Help:        "gorm-plugin: rows affected histogram",
+				ConstLabels: getDBConstLabel(dbName),
+				Labels:      labels,
+			},
+			Buckets: buckets,
+		}),
+		returned: meter.NewHistogram(HistogramOpts{
+			MetricOpts: MetricOpts{
+				Name:        fmt.Sprintf("%s_rows_returned", namePrefix),
 				Namespace:   namespace,
-				Help:        "gorm-plugin: error counter",
+				Help:        "gorm-plugin: rows returned histogram",
 				ConstLabels: getDBConstLabel(dbName),
+				Labels:      labels,
 			},
-			[]string{labelTableName, labelCallbackName},
-		),
+			Buckets: buckets,
+		}),
 	}
+	rows.affectedObservers = newObserverCache(rows.affected, labels, lb.len())
+	rows.returnedObservers = newObserverCache(rows.returned, labels, lb.len())
 
-	return &errorCounter
+	return &rows
 }
 
-// incSlowQuery increase slow query counter by 1.
-func (s *slowMetric) incSlowQuery(table, cbName string) {
-	labels := getDbAndTableMap(table)
-	labels[labelCallbackName] = cbName
-	s.counter.With(labels).Inc()
+// incSlowQuery increase slow query counter by 1. sql is used for
+// digest label.
+func (s *slowMetric) incSlowQuery(key metricKey, sql string) {
+	key.queryName = limitQueryName(s.queryNames, key.queryName)
+	if s.digestLabel {
+		key.digest = fingerprint.Digest(sql, s.fingerprintOpts...)
+	}
+	s.counters.get(key).Inc()
 }
 
 // timeQuery set query execution time histogram.
-func (s *slowMetric) timeQuery(table string, cost time.Duration) {
-	labels := getDbAndTableMap(table)
-	s.histogram.With(labels).Observe(float64(cost) / float64(time.Second))
+func (s *slowMetric) timeQuery(key metricKey, cost time.Duration) {
+	key.queryName = limitQueryName(s.queryNames, key.queryName)
+	if !s.callbackLabel {
+		key.callback = ""
+	}
+	s.observers.get(key).Observe(float64(cost) / float64(time.Second))
 }
 
 // incErrorQuery increase error query counter by 1.
-func (s *errorMetric) incErrorQuery(table, cbName string) {
-	labels := getDbAndTableMap(table)
-	labels[labelCallbackName] = cbName
-	s.counter.With(labels).Inc()
+func (s *errorMetric) incErrorQuery(key metricKey, err error) {
+	key.queryName = limitQueryName(s.queryNames, key.queryName)
+	if s.classifier != nil {
+		key.errorKind = s.classifier(err)
+	}
+	s.counters.get(key).Inc()
 }
 
-// getDbAndTableMap return a map for prometheus labels.
-func getDbAndTableMap(table string) map[string]string {
-	return map[string]string{
-		labelTableName: table,
+// limitQueryName return query_name label value of name, it is empty
+// if queryNames is nil.
+func limitQueryName(queryNames *valueLimiter, name string) string {
+	if queryNames == nil {
+		return ""
 	}
+	if name == "" {
+		name = UnnamedQuery
+	}
+	name, _ = queryNames.limit(name)
+	return name
+}
+
+// observeAffected set rows affected histogram.
+func (r *rowsMetric) observeAffected(key metricKey, rows int64) {
+	r.affectedObservers.get(key).Observe(float64(rows))
+}
+
+// observeReturned set rows returned histogram.
+func (r *rowsMetric) observeReturned(key metricKey, rows int64) {
+	r.returnedObservers.get(key).Observe(float64(rows))
 }
 
 // getDBConstLabel return label const label
@@ -1,22 +1,25 @@
 package query
 
 import (
+	"database/sql"
+	"fmt"
 	"time"
 
+	"github.com/changsongl/gorm-plugin/fingerprint"
 	"github.com/prometheus/client_golang/prometheus"
 	"gorm.io/gorm"
 )
 
 // Callback interface for query plugin
 type Callback interface {
-	apply(*gorm.DB)
+	apply(*gorm.DB) (restoreFunc, error)
 	getCollector() []prometheus.Collector
 }
 
 // cb implemented Callback with callback function
 // and prometheus collectors.
 type cb struct {
-	f    func(db *gorm.DB)
+	f    func(db *gorm.DB) (restoreFunc, error)
 	cols []prometheus.Collector
 }
 
@@ -24,21 +27,231 @@ type cb struct {
 // name, namespace and slow query threshold. It will stats
 // when slow query execution timeQuery is over SlowThreshold, and
 // store in counter and histogram in Namespace and with NamePrefix.
+// Mode is the way to install callbacks, default is ReplaceMode.
 type Config struct {
 	DBName        string
 	Namespace     string
 	NamePrefix    string
 	SlowThreshold time.Duration
+	Mode          Mode
+
+	// Meter creates query time histogram, slow query counter, error
+	// counter and rows histograms, default is PrometheusMeter. Use
+	// NewOTelMeter to record them with OpenTelemetry. In flight queries
+	// and LabelGuard are always prometheus collectors.
+	Meter Meter
+
+	// SlowThresholdRules are slow thresholds by table and callback, the
+	// first matched rule wins, SlowThreshold is used if no rule matches.
+	SlowThresholdRules []SlowThresholdRule
+	// Runtime is the config which can be changed at runtime. If it is
+	// set, SlowThreshold and SlowThresholdRules are ignored.
+	Runtime *Runtime
+
+	// HistogramCallbackLabel adds callback label to query time histogram,
+	// so latency can be split by operation. It is off by default to keep
+	// existing series.
+	HistogramCallbackLabel bool
+	// SlowQueryDigestLabel adds digest label to slow query counter, it is
+	// the digest of the sql fingerprint, so slow queries can be grouped by
+	// query shape.
+	SlowQueryDigestLabel bool
+	// QueryNameLabel adds query_name label to slow query counter, query
+	// time histogram and error counter. The value is settings.QueryName of
+	// the statement, or UnnamedQuery. Names over MaxQueryNames become
+	// OverflowLabelValue.
+	QueryNameLabel bool
+	// MaxQueryNames limits distinct query names, default is
+	// DefaultMaxQueryNames. Every callback has its own limit, so set
+	// QueryNames to share one between callbacks.
+	MaxQueryNames int
+	// QueryNames limits distinct query names of callbacks sharing it.
+	// MaxQueryNames is ignored if it is set.
+	QueryNames *QueryNames
+	// TableParse parses table names from SQL of statements without
+	// Statement.Table, such as db.Raw and db.Exec. It is off by default
+	// to keep existing series. Slow thresholds and muted tables match
+	// the parsed table too.
+	TableParse TableParseMode
+	// ANSIQuotes treats double quoted strings of SQL as identifiers when
+	// parsing tables and digests, like the ANSI_QUOTES sql mode of MySQL.
+	// By default they are string literals.
+	ANSIQuotes bool
+	// ExtraLabels are label names added to statement metrics, their
+	// values are extracted by LabelExtractor from the statement context.
+	// They must be declared up front, because metric labels can not be
+	// changed later. Metrics of TransactionCallback and PoolStatsCallback
+	// are not of a statement, they ignore ExtraLabels and LabelGuard.
+	ExtraLabels []string
+	// LabelExtractor extracts values of ExtraLabels, such as tenant or
+	// route. Keep the values bounded, every distinct combination is a
+	// new series.
+	LabelExtractor LabelExtractor
+	// LabelGuard normalizes table names, and limits distinct values of
+	// table_name and extra labels. Its collector is added to collectors
+	// of the callback.
+	LabelGuard *LabelGuard
+	// SlowQueryLog logs every query over SlowThreshold if it is set.
+	SlowQueryLog *SlowQueryLogOption
+	// Buckets of query time histogram (unit: second), default is
+	// DefaultBuckets. It is ignored if ExponentialBuckets is set.
+	Buckets []float64
+	// ExponentialBuckets generates buckets of query time histogram.
+	ExponentialBuckets *ExponentialBuckets
+	// NativeHistogramBucketFactor enables prometheus native histogram
+	// for query time if it is greater than 1. The classic buckets are
+	// still exposed.
+	NativeHistogramBucketFactor float64
+	// NativeHistogramMaxBucketNumber limits the number of native
+	// histogram buckets, 0 means no limit.
+	NativeHistogramMaxBucketNumber uint32
+
+	// RowsBuckets of rows histograms, default is DefaultRowsBuckets.
+	RowsBuckets []float64
+	// TxStatementsBuckets of statements per transaction histogram,
+	// default is DefaultTxStatementsBuckets. Transaction duration uses
+	// buckets of query time.
+	TxStatementsBuckets []float64
+	// LongTx calls a hook for transactions open over its threshold, it is
+	// used by TransactionCallback.
+	LongTx *LongTxOption
+	// Pools are extra connection pools of PoolStatsCallback by name, such
+	// as sources and replicas of dbresolver. The pool of gorm db is always
+	// collected as PrimaryPool.
+	Pools map[string]*sql.DB
+
+	// ErrorKindLabel adds error_kind label to error counter. It is off
+	// by default to keep existing series.
+	ErrorKindLabel bool
+	// ErrorClassifier classifies errors for error_kind label, default
+	// is DefaultErrorClassifier.
+	ErrorClassifier ErrorClassifier
+}
+
+// ExponentialBuckets is for generating histogram buckets. The first
+// bucket is Start, and every bucket is Factor times the previous one.
+type ExponentialBuckets struct {
+	Start  float64
+	Factor float64
+	Count  int
+}
+
+// slowMetricConfig return slow metric config. It returns
+// an error and DefaultBuckets, if the buckets config is invalid,
+// and an error without labeler if extra labels are invalid.
+func (c Config) slowMetricConfig() (slowMetricConfig, error) {
+	buckets, bErr := c.buckets()
+	lb, err := c.labeler()
+	hc := slowMetricConfig{
+		labeler:               lb,
+		digestLabel:           c.SlowQueryDigestLabel,
+		queryNames:            c.queryNameLimiter(),
+		fingerprintOpts:       c.fingerprintOptions(),
+		callbackLabel:         c.HistogramCallbackLabel,
+		buckets:               buckets,
+		nativeBucketFactor:    c.NativeHistogramBucketFactor,
+		nativeMaxBucketNumber: c.NativeHistogramMaxBucketNumber,
+	}
+	if bErr != nil {
+		return hc, bErr
+	}
+	return hc, err
+}
+
+// buckets return buckets of query time histogram. It returns an error
+// and DefaultBuckets if the buckets config is invalid.
+func (c Config) buckets() ([]float64, error) {
+	if eb := c.ExponentialBuckets; eb != nil {
+		if eb.Start <= 0 || eb.Factor <= 1 || eb.Count < 1 {
+			return DefaultBuckets, fmt.Errorf("query plugin: invalid exponential buckets %+v", *eb)
+		}
+		return prometheus.ExponentialBuckets(eb.Start, eb.Factor, eb.Count), nil
+	}
+	for i := 1; i < len(c.Buckets); i++ {
+		if c.Buckets[i] <= c.Buckets[i-1] {
+			return DefaultBuckets, fmt.Errorf("query plugin: buckets %v are not in increasing order", c.Buckets)
+		}
+	}
+	if len(c.Buckets) != 0 {
+		return c.Buckets, nil
+	}
+	return DefaultBuckets, nil
+}
+
+// labeler return labeler of ExtraLabels, LabelExtractor, LabelGuard and
+// TableParse. It returns an error and nil if a label name is invalid.
+func (c Config) labeler() (*labeler, error) {
+	if len(c.ExtraLabels) == 0 && c.LabelGuard == nil && c.TableParse == TableParseOff {
+		return nil, nil
+	}
+	return newLabeler(c.ExtraLabels, c.LabelExtractor, c.LabelGuard, newTableParser(c.TableParse, c.fingerprintOptions()...))
+}
+
+// fingerprintOptions return options of parsing SQL by fingerprint.
+func (c Config) fingerprintOptions() []fingerprint.Option {
+	if c.ANSIQuotes {
+		return []fingerprint.Option{fingerprint.ANSIQuotes()}
+	}
+	return nil
+}
+
+// queryNameLimiter return a valueLimiter of query names, it is nil if
+// QueryNameLabel is false.
+func (c Config) queryNameLimiter() *valueLimiter {
+	if !c.QueryNameLabel {
+		return nil
+	}
+	if c.QueryNames != nil {
+		return c.QueryNames.limiter
+	}
+
+	max := c.MaxQueryNames
+	if max <= 0 {
+		max = DefaultMaxQueryNames
+	}
+	return newValueLimiter(max)
+}
+
+// meter return Meter, default is PrometheusMeter.
+func (c Config) meter() Meter {
+	if c.Meter == nil {
+		return PrometheusMeter{}
+	}
+	return c.Meter
+}
+
+// collectors return metrics which are prometheus collectors, and
+// collector of LabelGuard if it is set.
+func (c Config) collectors(metrics ...interface{}) []prometheus.Collector {
+	var cols []prometheus.Collector
+	for _, m := range metrics {
+		if col, ok := m.(prometheus.Collector); ok {
+			cols = append(cols, col)
+		}
+	}
+	if c.LabelGuard != nil {
+		cols = append(cols, c.LabelGuard)
+	}
+	return cols
 }
 
 // NewCallback return a Callback interface.
 func NewCallback(f func(db *gorm.DB), cols ...prometheus.Collector) Callback {
+	return newCallback(func(db *gorm.DB) (restoreFunc, error) {
+		f(db)
+		return nil, nil
+	}, cols...)
+}
+
+// newCallback return a Callback interface with a function
+// which may fail, and may return a restoreFunc to undo it.
+func newCallback(f func(db *gorm.DB) (restoreFunc, error), cols ...prometheus.Collector) Callback {
 	return cb{f: f, cols: cols}
 }
 
 // apply is a implementation function of Callback for cb
-func (o cb) apply(db *gorm.DB) {
-	o.f(db)
+func (o cb) apply(db *gorm.DB) (restoreFunc, error) {
+	return o.f(db)
 }
 
 // getCollector is a implementation function of Callback for cb
@@ -47,25 +260,193 @@ func (o cb) getCollector() []prometheus.Collector {
 }
 
 // SlowQueryCallback returns a Callback. And replace all kind of Callback
-// with slow query stats function.
+// with slow query stats function. Invalid histogram config and slow
+// threshold rules are reported by Initialize. Slow thresholds are read
+// from Runtime if it is set.
 func SlowQueryCallback(c Config) Callback {
-	slowMetric := newSlowMetric(c.NamePrefix, c.Namespace, c.DBName)
-	cbFunc := func(db *gorm.DB) {
-		s := newSlowCallback(db, c.SlowThreshold, slowMetric)
-		replaceAllCallback(s)
+	hc, hcErr := c.slowMetricConfig()
+	runtime, rtErr := c.runtime()
+	slowMetric := newSlowMetric(c.meter(), c.NamePrefix, c.Namespace, c.DBName, hc)
+	cbFunc := func(db *gorm.DB) (restoreFunc, error) {
+		if hcErr != nil {
+			return nil, hcErr
+		}
+		if rtErr != nil {
+			return nil, rtErr
+		}
+		s := newSlowCallback(db, runtime, slowMetric, c.SlowQueryLog, c.Mode)
+		return replaceAllCallback(s)
 	}
 
-	return NewCallback(cbFunc, slowMetric.counter, slowMetric.histogram)
+	return newCallback(cbFunc, c.collectors(slowMetric.counter, slowMetric.histogram)...)
 }
 
 // ErrorQueryCallback returns a Callback. And replace all kind of Callback
 // with error query stats function.
 func ErrorQueryCallback(c Config) Callback {
-	errorMetric := newErrorMetric(c.NamePrefix, c.Namespace, c.DBName)
-	cbFunc := func(db *gorm.DB) {
-		e := newErrorCallback(db, errorMetric)
-		replaceAllCallback(e)
+	var classifier ErrorClassifier
+	if c.ErrorKindLabel {
+		classifier = c.ErrorClassifier
+		if classifier == nil {
+			classifier = DefaultErrorClassifier
+		}
+	}
+
+	lb, elErr := c.labeler()
+	runtime, rtErr := c.runtime()
+	errorMetric := newErrorMetric(c.meter(), c.NamePrefix, c.Namespace, c.DBName, classifier, c.queryNameLimiter(), lb)
+	cbFunc := func(db *gorm.DB) (restoreFunc, error) {
+		if elErr != nil {
+			return nil, elErr
+		}
+		if rtErr != nil {
+			return nil, rtErr
+		}
+		e := newErrorCallback(db, runtime, errorMetric, c.Mode)
+		return replaceAllCallback(e)
+	}
+
+	return newCallback(cbFunc, c.collectors(errorMetric.counter)...)
+}
+
+// RowsCallback returns a Callback. And replace all kind of Callback
+// with rows stats function. It records rows affected of create, update,
+// delete and raw, and rows returned of query and row. Rows of row are
+// recorded by the logger of db when db.Scan traces them, so they are not
+// recorded for db.Row and db.Rows.
+func RowsCallback(c Config) Callback {
+	buckets := c.RowsBuckets
+	if len(buckets) == 0 {
+		buckets = DefaultRowsBuckets
+	}
+
+	lb, elErr := c.labeler()
+	runtime, rtErr := c.runtime()
+	rowsMetric := newRowsMetric(c.meter(), c.NamePrefix, c.Namespace, c.DBName, buckets, lb)
+	cbFunc := func(db *gorm.DB) (restoreFunc, error) {
+		if elErr != nil {
+			return nil, elErr
+		}
+		if rtErr != nil {
+			return nil, rtErr
+		}
+		r := newInterceptorCallback(db, rowsMetricInterceptor(runtime, rowsMetric), c.Mode)
+		restore, err := replaceAllCallback(r)
+		if err != nil {
+			return restore, err
+		}
+		return restoreAll([]restoreFunc{restore, installRowsLogger(db, rowsMetric)}), nil
+	}
+
+	return newCallback(cbFunc, c.collectors(rowsMetric.affected, rowsMetric.returned)...)
+}
+
+// InFlightCallback returns a Callback. And replace all kind of Callback
+// with in flight query stats function. It records queries in progress
+// and the peak of them since last scrape, by table and callback.
+func InFlightCallback(c Config) Callback {
+	lb, elErr := c.labeler()
+	runtime, rtErr := c.runtime()
+	inFlightMetric := newInFlightMetric(c.NamePrefix, c.Namespace, c.DBName, lb)
+	cbFunc := func(db *gorm.DB) (restoreFunc, error) {
+		if elErr != nil {
+			return nil, elErr
+		}
+		if rtErr != nil {
+			return nil, rtErr
+		}
+		i := newInFlightCallback(db, runtime, inFlightMetric, c.Mode)
+		return replaceAllCallback(i)
+	}
+
+	return newCallback(cbFunc, c.collectors(inFlightMetric)...)
+}
+
+// PoolStatsCallback returns a Callback. It collects sql.DBStats of the
+// connection pool of db, and Pools of Config, at scrape time. The pool
+// label is the name of the pool. It is a prometheus collector, Meter is
+// not used.
+func PoolStatsCallback(c Config) Callback {
+	poolMetric := newPoolStatsMetric(c.NamePrefix, c.Namespace, c.DBName)
+	cbFunc := func(db *gorm.DB) (restoreFunc, error) {
+		pools, err := poolsOf(db, c.Pools)
+		if err != nil {
+			return nil, err
+		}
+		return poolMetric.add(pools)
+	}
+
+	return newCallback(cbFunc, poolMetric)
+}
+
+// TransactionCallback returns a Callback. It wraps the ConnPool of db,
+// and records duration, outcome and statements of every transaction begun
+// on it, including db.Transaction, db.Begin and the default transaction
+// of create, update and delete. Sessions with PrepareStmt use their own
+// ConnPool, so their transactions are not recorded. If LongTx is set,
+// transactions open over its threshold are checked in background until
+// the plugin is closed.
+func TransactionCallback(c Config) Callback {
+	statementsBuckets := c.TxStatementsBuckets
+	if len(statementsBuckets) == 0 {
+		statementsBuckets = DefaultTxStatementsBuckets
+	}
+
+	buckets, bErr := c.buckets()
+	runtime, rtErr := c.runtime()
+	ltErr := c.LongTx.check()
+	txMetric := newTxMetric(c.meter(), c.NamePrefix, c.Namespace, c.DBName, buckets, statementsBuckets)
+	cbFunc := func(db *gorm.DB) (restoreFunc, error) {
+		if bErr != nil {
+			return nil, bErr
+		}
+		if rtErr != nil {
+			return nil, rtErr
+		}
+		if ltErr != nil {
+			return nil, ltErr
+		}
+
+		detector := newLongTxDetector(c.LongTx, runtime)
+		tracker := &txTracker{runtime: runtime, metric: txMetric, detector: detector, now: c.LongTx.now()}
+		restore, err := installTxConnPool(db, tracker)
+		if err != nil {
+			return nil, err
+		}
+
+		stop := detector.start()
+		return func() error {
+			stop()
+			return restore()
+		}, nil
+	}
+
+	return newCallback(cbFunc, c.collectors(txMetric.duration, txMetric.statements, txMetric.counter)...)
+}
+
+// InterceptorCallback returns a Callback. And replace all kind of Callback
+// (create, update, delete, query, raw and row) with the given interceptors.
+// The first interceptor is the outermost one, so it runs first before the
+// gorm handler and last after it.
+func InterceptorCallback(interceptors ...Interceptor) Callback {
+	return interceptorCallbackWithMode(ReplaceMode, interceptors...)
+}
+
+// InterceptorHookCallback is same as InterceptorCallback, but the
+// interceptors are installed with HookMode. Their next Handler does
+// nothing, use StartTime to get the start time of the statement.
+func InterceptorHookCallback(interceptors ...Interceptor) Callback {
+	return interceptorCallbackWithMode(HookMode, interceptors...)
+}
+
+// interceptorCallbackWithMode returns a Callback installing
+// interceptors with mode.
+func interceptorCallbackWithMode(mode Mode, interceptors ...Interceptor) Callback {
+	interceptor := chainInterceptors(interceptors...)
+	cbFunc := func(db *gorm.DB) (restoreFunc, error) {
+		i := newInterceptorCallback(db, interceptor, mode)
+		return replaceAllCallback(i)
 	}
 
-	return NewCallback(cbFunc, errorMetric.counter)
+	return newCallback(cbFunc)
 }
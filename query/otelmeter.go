@@ -0,0 +1,113 @@
+package query
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// otelMeter creates OpenTelemetry instruments.
+type otelMeter struct {
+	meter metric.Meter
+}
+
+// NewOTelMeter return a Meter creating instruments with meter. Names
+// of instruments are same as prometheus ones, and labels are
+// attributes. Errors of creating instruments are sent to otel.Handle.
+func NewOTelMeter(meter metric.Meter) Meter {
+	return otelMeter{meter: meter}
+}
+
+// NewCounter implements Meter
+func (m otelMeter) NewCounter(opts MetricOpts) CounterVec {
+	counter, err := m.meter.Int64Counter(
+		prometheus.BuildFQName(opts.Namespace, "", opts.Name),
+		metric.WithDescription(opts.Help),
+		metric.WithUnit(opts.Unit),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	return otelCounterVec{counter: counter, opts: opts}
+}
+
+// NewHistogram implements Meter
+func (m otelMeter) NewHistogram(opts HistogramOpts) HistogramVec {
+	histogram, err := m.meter.Float64Histogram(
+		prometheus.BuildFQName(opts.Namespace, "", opts.Name),
+		metric.WithDescription(opts.Help),
+		metric.WithUnit(opts.Unit),
+		metric.WithExplicitBucketBoundaries(opts.Buckets...),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	return otelHistogramVec{histogram: histogram, opts: opts.MetricOpts}
+}
+
+// attributeSet return attributes of const labels and label values.
+func attributeSet(opts MetricOpts, lvs []string) attribute.Set {
+	attrs := make([]attribute.KeyValue, 0, len(opts.ConstLabels)+len(lvs))
+	for k, v := range opts.ConstLabels {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	for i, label := range opts.Labels {
+		if i < len(lvs) {
+			attrs = append(attrs, attribute.String(label, lvs[i]))
+		}
+	}
+	return attribute.NewSet(attrs...)
+}
+
+// otelCounterVec is a CounterVec of an otel counter
+type otelCounterVec struct {
+	counter metric.Int64Counter
+	opts    MetricOpts
+}
+
+// WithLabelValues implements CounterVec
+func (v otelCounterVec) WithLabelValues(lvs ...string) Counter {
+	return otelCounter{
+		counter: v.counter,
+		opts:    []metric.AddOption{metric.WithAttributeSet(attributeSet(v.opts, lvs))},
+	}
+}
+
+// otelCounter is a Counter with attributes
+type otelCounter struct {
+	counter metric.Int64Counter
+	opts    []metric.AddOption
+}
+
+// Inc implements Counter
+func (c otelCounter) Inc() {
+	c.counter.Add(context.Background(), 1, c.opts...)
+}
+
+// otelHistogramVec is a HistogramVec of an otel histogram
+type otelHistogramVec struct {
+	histogram metric.Float64Histogram
+	opts      MetricOpts
+}
+
+// WithLabelValues implements HistogramVec
+func (v otelHistogramVec) WithLabelValues(lvs ...string) Observer {
+	return otelObserver{
+		histogram: v.histogram,
+		opts:      []metric.RecordOption{metric.WithAttributeSet(attributeSet(v.opts, lvs))},
+	}
+}
+
+// otelObserver is an Observer with attributes
+type otelObserver struct {
+	histogram metric.Float64Histogram
+	opts      []metric.RecordOption
+}
+
+// Observe implements Observer
+func (o otelObserver) Observe(v float64) {
+	o.histogram.Record(context.Background(), v, o.opts...)
+}
@@ -1,6 +1,8 @@
 package query
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"gorm.io/gorm"
 )
@@ -12,38 +14,137 @@ type MetricPlugin interface {
 	Name() string
 	Initialize(db *gorm.DB) error
 	MetricsCollectors() []prometheus.Collector
+	Close(db *gorm.DB) error
 }
 
 // metricPlugin implemented MetricPlugin and prometheus.Plugin
 // of gorm v2.
 type metricPlugin struct {
+	name string
 	opts []Callback
 	cols []prometheus.Collector
+
+	registerer prometheus.Registerer
+
+	mu         sync.Mutex
+	restores   map[*gorm.DB][]restoreFunc
+	registered []prometheus.Collector
 }
 
-// New a query metric plugin which can monitor query timing
+// New a query metric plugin which can monitor query timing. LabelGuard
+// shared by callbacks is collected once. Collectors are registered when
+// the plugin is initialized, if RegistererOption is in opts.
 func New(opts ...Callback) MetricPlugin {
-	m := &metricPlugin{opts: opts}
+	return NewWithName("gorm-plugin:metric", opts...)
+}
+
+// NewWithName is same as New, but the plugin is named name, so more
+// than one of them can be used on the same db.
+func NewWithName(name string, opts ...Callback) MetricPlugin {
+	m := &metricPlugin{name: name, opts: opts, restores: map[*gorm.DB][]restoreFunc{}}
+	guards := map[*LabelGuard]bool{}
 	for _, opt := range m.opts {
-		m.cols = append(m.cols, opt.getCollector()...)
+		if o, ok := opt.(registererOption); ok {
+			m.registerer = o.registerer
+		}
+		for _, col := range opt.getCollector() {
+			if g, ok := col.(*LabelGuard); ok {
+				if guards[g] {
+					continue
+				}
+				guards[g] = true
+			}
+			m.cols = append(m.cols, col)
+		}
 	}
 	return m
 }
 
 // Name for metric plugin
 func (m *metricPlugin) Name() string {
-	return "gorm-plugin:metric"
+	return m.name
 }
 
-// Initialize replace gorm callbacks
+// Initialize replace gorm callbacks. It registers collectors if the
+// plugin has a registerer, applies all options, and return all errors
+// of them. If any of them failed, the applied ones are restored and the
+// registered collectors are unregistered, so it can be initialized again.
 func (m *metricPlugin) Initialize(db *gorm.DB) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs callbackErrors
+	var registered []prometheus.Collector
+	first := m.registerer != nil && len(m.restores) == 0
+	if first {
+		var err error
+		registered, err = register(m.registerer, m.cols)
+		if err != nil {
+			errs = errs.append(err)
+		}
+	}
+
+	var restores []restoreFunc
 	for _, opt := range m.opts {
-		opt.apply(db)
+		restore, err := opt.apply(db)
+		if restore != nil {
+			restores = append(restores, restore)
+		}
+		if err != nil {
+			errs = errs.append(err)
+		}
 	}
 
+	if err := errs.errOrNil(); err != nil {
+		if rErr := restoreAll(restores)(); rErr != nil {
+			errs = errs.append(rErr)
+		}
+		if first {
+			unregister(m.registerer, registered)
+		}
+		return errs.errOrNil()
+	}
+
+	if first {
+		m.registered = registered
+	}
+	m.restores[db] = restores
 	return nil
 }
 
+// Close restores gorm callbacks of db changed by Initialize, and
+// removes the plugin from db, so it can be used again. Replaced callbacks
+// are not put back, their handlers pass statements through, so plugins
+// can be closed in any order, and handlers of other plugins installed
+// after this plugin are kept. It unregisters collectors from the
+// registerer of RegistererOption, or prometheus.DefaultRegisterer, when
+// the plugin is closed on all of db it initialized. Collectors used by
+// other plugins are kept.
+func (m *metricPlugin) Close(db *gorm.DB) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	restores, ok := m.restores[db]
+	if !ok {
+		return nil
+	}
+	delete(m.restores, db)
+	delete(db.Config.Plugins, m.Name())
+
+	err := restoreAll(restores)()
+	if len(m.restores) == 0 {
+		if m.registerer != nil {
+			unregister(m.registerer, m.registered)
+			m.registered = nil
+		} else {
+			for _, col := range m.cols {
+				prometheus.Unregister(col)
+			}
+		}
+	}
+	return err
+}
+
 // MetricsCollectors return a set of collector for prometheus,
 // so you can use prometheus.register to register them.
 func (m *metricPlugin) MetricsCollectors() []prometheus.Collector {
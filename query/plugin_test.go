@@ -0,0 +1,196 @@
+package query
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+)
+
+// countInterceptor return an Interceptor counting statements of every
+// callback in n.
+func countInterceptor(n map[string]int) Interceptor {
+	return func(cbName string) func(next Handler) Handler {
+		return func(next Handler) Handler {
+			return func(db *gorm.DB) {
+				next(db)
+				n[cbName]++
+			}
+		}
+	}
+}
+
+func TestReplaceAllCallbackErrors(t *testing.T) {
+	for name, mode := range map[string]Mode{"replace": ReplaceMode, "hook": HookMode} {
+		t.Run(name, func(t *testing.T) { testReplaceAllCallbackErrors(t, mode) })
+	}
+}
+
+func testReplaceAllCallbackErrors(t *testing.T, mode Mode) {
+	db := newDryRunDB(t)
+	if err := db.Callback().Query().Remove("gorm:query"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Callback().Row().Remove("gorm:row"); err != nil {
+		t.Fatal(err)
+	}
+
+	n := map[string]int{}
+	restore, err := replaceAllCallback(newInterceptorCallback(db, countInterceptor(n), mode))
+	errs, ok := err.(callbackErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("error = %v, want errors of gorm:query and gorm:row", err)
+	}
+	for _, msg := range []string{"callback gorm:query not found", "callback gorm:row not found"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("error %q does not name the callback, want %q", err, msg)
+		}
+	}
+
+	// other callbacks are replaced, and restored by restore.
+	db.Exec("UPDATE users SET name = ?", "bob")
+	if n["gorm:raw"] != 1 {
+		t.Fatalf("raw statements = %d, want 1", n["gorm:raw"])
+	}
+	if err := restore(); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("UPDATE users SET name = ?", "bob")
+	if n["gorm:raw"] != 1 {
+		t.Errorf("raw statements after restore = %d, want 1", n["gorm:raw"])
+	}
+}
+
+func TestInitializeErrorsOfAllOptions(t *testing.T) {
+	db := newDryRunDB(t)
+	err := db.Use(New(
+		SlowQueryCallback(Config{NamePrefix: "gorm", Buckets: []float64{2, 1}}),
+		ErrorQueryCallback(Config{NamePrefix: "gorm", ExtraLabels: []string{"bad-label"}}),
+	))
+	if err == nil {
+		t.Fatal("Initialize succeeded with invalid options")
+	}
+	for _, msg := range []string{"buckets [2 1] are not in increasing order", "bad-label"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("error %q does not contain %q", err, msg)
+		}
+	}
+}
+
+func TestInitializeUndoesOnError(t *testing.T) {
+	db := newDryRunDB(t)
+	if err := db.Callback().Query().Remove("gorm:query"); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := prometheus.NewRegistry()
+	c := Config{NamePrefix: "gorm", SlowThreshold: time.Nanosecond}
+	plugin := New(RegistererOption(registry), SlowQueryCallback(c))
+	err := db.Use(plugin)
+	if err == nil || !strings.Contains(err.Error(), "query plugin: callback gorm:query not found") {
+		t.Fatalf("error = %v, want gorm:query not found", err)
+	}
+	if _, ok := db.Plugins[plugin.Name()]; ok {
+		t.Error("plugin is used after Initialize failed")
+	}
+	if mfs, err := registry.Gather(); err != nil || len(mfs) != 0 {
+		t.Errorf("registered %d metric families after Initialize failed, want 0", len(mfs))
+	}
+
+	// callbacks applied before the error are restored, so nothing is
+	// recorded, and the plugin can be used again.
+	db.Exec("UPDATE users SET name = ?", "bob")
+	if err := db.Callback().Query().Register("gorm:query", callbacks.Query); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(plugin); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("UPDATE users SET name = ?", "bob")
+	if v, _ := gatherValue(t, registry, "gorm_slow_query_count"); v != 1 {
+		t.Errorf("slow queries = %v, want 1 of the statement after Use", v)
+	}
+}
+
+// useCountPlugins uses plugins named names on db in order, every one
+// counts statements of gorm:query in n by its name.
+func useCountPlugins(t *testing.T, db *gorm.DB, n map[string]int, names ...string) map[string]MetricPlugin {
+	plugins := map[string]MetricPlugin{}
+	for _, name := range names {
+		name := name
+		plugins[name] = NewWithName(name, InterceptorCallback(func(cbName string) func(next Handler) Handler {
+			return func(next Handler) Handler {
+				return func(db *gorm.DB) {
+					next(db)
+					if cbName == "gorm:query" {
+						n[name]++
+					}
+				}
+			}
+		}))
+		if err := db.Use(plugins[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return plugins
+}
+
+func TestCloseInOrder(t *testing.T) {
+	db := newDryRunDB(t)
+	n := map[string]int{}
+	plugins := useCountPlugins(t, db, n, "a", "b")
+
+	for _, name := range []string{"a", "b"} {
+		if err := plugins[name].Close(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Table("users").Find(&[]rowsModel{})
+	if n["a"] != 0 || n["b"] != 0 {
+		t.Errorf("statements of closed plugins = %v, want none", n)
+	}
+}
+
+func TestCloseKeepsPluginsAfter(t *testing.T) {
+	db := newDryRunDB(t)
+	n := map[string]int{}
+	plugins := useCountPlugins(t, db, n, "a", "b")
+
+	if err := plugins["a"].Close(db); err != nil {
+		t.Fatal(err)
+	}
+	db.Table("users").Find(&[]rowsModel{})
+	if n["a"] != 0 || n["b"] != 1 {
+		t.Errorf("statements = %v, want only b", n)
+	}
+
+	if err := plugins["b"].Close(db); err != nil {
+		t.Fatal(err)
+	}
+	db.Table("users").Find(&[]rowsModel{})
+	if n["a"] != 0 || n["b"] != 1 {
+		t.Errorf("statements after closing b = %v, want none more", n)
+	}
+}
+
+func TestUseAgainAfterClose(t *testing.T) {
+	db := newDryRunDB(t)
+	n := map[string]int{}
+	plugins := useCountPlugins(t, db, n, "a")
+
+	for i := 1; i <= 3; i++ {
+		if err := plugins["a"].Close(db); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Use(plugins["a"]); err != nil {
+			t.Fatalf("use plugin again: %v", err)
+		}
+		db.Table("users").Find(&[]rowsModel{})
+		if n["a"] != i {
+			t.Fatalf("statements after using %d times = %d, want %d", i+1, n["a"], i)
+		}
+	}
+}
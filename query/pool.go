@@ -0,0 +1,187 @@
+package query
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+// PrimaryPool is the pool label of the connection pool of gorm db.
+const PrimaryPool = "primary"
+
+// labelPool is the label of connection pool name
+const labelPool = "pool"
+
+// poolStatsMetric is a prometheus collector of sql.DBStats of connection
+// pools by pool name. Stats are read at scrape time.
+type poolStatsMetric struct {
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxIdleTimeClosed *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+
+	pools *poolSet
+}
+
+// poolSet is a set of connection pools. Pools of db sharing the metric
+// can have the same name, their stats are summed.
+type poolSet struct {
+	mu sync.RWMutex
+	m  map[poolKey]struct{}
+}
+
+// poolKey is a connection pool with its name.
+type poolKey struct {
+	name string
+	pool *sql.DB
+}
+
+// newPoolStatsMetric return a poolStatsMetric
+func newPoolStatsMetric(namePrefix, namespace, dbName string) *poolStatsMetric {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", fmt.Sprintf("%s_%s", namePrefix, name)),
+			help, []string{labelPool}, getDBConstLabel(dbName),
+		)
+	}
+
+	return &poolStatsMetric{
+		maxOpen:           desc("pool_max_open_connections", "gorm-plugin: maximum number of open connections"),
+		open:              desc("pool_open_connections", "gorm-plugin: number of established connections, in use and idle"),
+		inUse:             desc("pool_in_use_connections", "gorm-plugin: number of connections in use"),
+		idle:              desc("pool_idle_connections", "gorm-plugin: number of idle connections"),
+		waitCount:         desc("pool_wait_count", "gorm-plugin: total number of connections waited for"),
+		waitDuration:      desc("pool_wait_duration_seconds", "gorm-plugin: total time blocked waiting for connections (unit: second)"),
+		maxIdleClosed:     desc("pool_max_idle_closed_count", "gorm-plugin: total number of connections closed due to max idle connections"),
+		maxIdleTimeClosed: desc("pool_max_idle_time_closed_count", "gorm-plugin: total number of connections closed due to max idle time"),
+		maxLifetimeClosed: desc("pool_max_lifetime_closed_count", "gorm-plugin: total number of connections closed due to max lifetime"),
+		pools:             &poolSet{m: map[poolKey]struct{}{}},
+	}
+}
+
+// add adds pools by name, it returns error if a pool is added with the
+// same name, or a pool is nil. The returned restoreFunc removes them.
+func (m *poolStatsMetric) add(pools map[string]*sql.DB) (restoreFunc, error) {
+	set := m.pools
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	for name, pool := range pools {
+		if pool == nil {
+			return nil, fmt.Errorf("query plugin: connection pool %s is nil", name)
+		}
+		if _, ok := set.m[poolKey{name: name, pool: pool}]; ok {
+			return nil, fmt.Errorf("query plugin: connection pool %s is added", name)
+		}
+	}
+	for name, pool := range pools {
+		set.m[poolKey{name: name, pool: pool}] = struct{}{}
+	}
+
+	restore := func() error {
+		set.mu.Lock()
+		defer set.mu.Unlock()
+		for name, pool := range pools {
+			delete(set.m, poolKey{name: name, pool: pool})
+		}
+		return nil
+	}
+	return restore, nil
+}
+
+// Describe implements prometheus.Collector
+func (m *poolStatsMetric) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.maxOpen
+	ch <- m.open
+	ch <- m.inUse
+	ch <- m.idle
+	ch <- m.waitCount
+	ch <- m.waitDuration
+	ch <- m.maxIdleClosed
+	ch <- m.maxIdleTimeClosed
+	ch <- m.maxLifetimeClosed
+}
+
+// Collect implements prometheus.Collector
+func (m *poolStatsMetric) Collect(ch chan<- prometheus.Metric) {
+	set := m.pools
+	set.mu.RLock()
+	pools := map[string][]*sql.DB{}
+	for key := range set.m {
+		pools[key.name] = append(pools[key.name], key.pool)
+	}
+	set.mu.RUnlock()
+
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := sumStats(pools[name])
+		ch <- prometheus.MustNewConstMetric(m.maxOpen, prometheus.GaugeValue, float64(s.MaxOpenConnections), name)
+		ch <- prometheus.MustNewConstMetric(m.open, prometheus.GaugeValue, float64(s.OpenConnections), name)
+		ch <- prometheus.MustNewConstMetric(m.inUse, prometheus.GaugeValue, float64(s.InUse), name)
+		ch <- prometheus.MustNewConstMetric(m.idle, prometheus.GaugeValue, float64(s.Idle), name)
+		ch <- prometheus.MustNewConstMetric(m.waitCount, prometheus.CounterValue, float64(s.WaitCount), name)
+		ch <- prometheus.MustNewConstMetric(m.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(m.maxIdleClosed, prometheus.CounterValue, float64(s.MaxIdleClosed), name)
+		ch <- prometheus.MustNewConstMetric(m.maxIdleTimeClosed, prometheus.CounterValue, float64(s.MaxIdleTimeClosed), name)
+		ch <- prometheus.MustNewConstMetric(m.maxLifetimeClosed, prometheus.CounterValue, float64(s.MaxLifetimeClosed), name)
+	}
+}
+
+// sumStats return the sum of stats of pools.
+func sumStats(pools []*sql.DB) sql.DBStats {
+	var sum sql.DBStats
+	for _, pool := range pools {
+		s := pool.Stats()
+		sum.MaxOpenConnections += s.MaxOpenConnections
+		sum.OpenConnections += s.OpenConnections
+		sum.InUse += s.InUse
+		sum.Idle += s.Idle
+		sum.WaitCount += s.WaitCount
+		sum.WaitDuration += s.WaitDuration
+		sum.MaxIdleClosed += s.MaxIdleClosed
+		sum.MaxIdleTimeClosed += s.MaxIdleTimeClosed
+		sum.MaxLifetimeClosed += s.MaxLifetimeClosed
+	}
+	return sum
+}
+
+// reuse implements reusable, pools are collected by the existing
+// poolStatsMetric, pools with the same name of them are summed.
+func (m *poolStatsMetric) reuse(existing prometheus.Collector) bool {
+	e, ok := existing.(*poolStatsMetric)
+	if ok {
+		m.pools = e.pools
+	}
+	return ok
+}
+
+// poolsOf return the pool of db as PrimaryPool, and extra pools.
+func poolsOf(db *gorm.DB, extra map[string]*sql.DB) (map[string]*sql.DB, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("query plugin: connection pool of db not found: %w", err)
+	}
+
+	pools := map[string]*sql.DB{PrimaryPool: sqlDB}
+	for name, pool := range extra {
+		if name == PrimaryPool {
+			return nil, fmt.Errorf("query plugin: connection pool name %s is reserved", PrimaryPool)
+		}
+		pools[name] = pool
+	}
+	return pools, nil
+}
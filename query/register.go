@@ -0,0 +1,108 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+// reusable is a collector which can record to an existing collector of
+// the same metrics, when they are registered already. It returns false
+// if existing is not the same kind of collector.
+type reusable interface {
+	reuse(existing prometheus.Collector) bool
+}
+
+// registrations counts plugins using collectors registered by plugins,
+// so a collector is unregistered when the last of them is closed.
+// Collectors registered by others are never unregistered by plugins.
+var registrations = struct {
+	mu sync.Mutex
+	m  map[prometheus.Collector]int
+}{m: map[prometheus.Collector]int{}}
+
+// registererOption is a Callback setting the prometheus.Registerer of
+// the plugin. It changes nothing of db.
+type registererOption struct {
+	registerer prometheus.Registerer
+}
+
+// RegistererOption registers collectors of the plugin to registerer when
+// the plugin is initialized, and unregisters them when it is closed on
+// all of db. If a collector is registered already, such as by another
+// plugin with the same Namespace, NamePrefix and DBName, the existing one
+// is reused, so metrics of many db can share one metric family. Default
+// registerer is prometheus.DefaultRegisterer.
+func RegistererOption(registerer prometheus.Registerer) Callback {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	return registererOption{registerer: registerer}
+}
+
+// apply implements Callback
+func (registererOption) apply(*gorm.DB) (restoreFunc, error) {
+	return nil, nil
+}
+
+// getCollector implements Callback
+func (registererOption) getCollector() []prometheus.Collector {
+	return nil
+}
+
+// register registers cols to registerer. A collector registered already
+// is reused, if it is reusable. It returns collectors used by plugins,
+// they should be passed to unregister later. Nothing is registered if it
+// returns an error.
+func register(registerer prometheus.Registerer, cols []prometheus.Collector) ([]prometheus.Collector, error) {
+	registrations.mu.Lock()
+	defer registrations.mu.Unlock()
+
+	var registered []prometheus.Collector
+	for _, col := range cols {
+		err := registerer.Register(col)
+		if err == nil {
+			registrations.m[col]++
+			registered = append(registered, col)
+			continue
+		}
+
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if r, ok := col.(reusable); ok && r.reuse(are.ExistingCollector) {
+				if _, ok := registrations.m[are.ExistingCollector]; ok {
+					registrations.m[are.ExistingCollector]++
+					registered = append(registered, are.ExistingCollector)
+				}
+				continue
+			}
+		}
+
+		unregisterLocked(registerer, registered)
+		return nil, fmt.Errorf("query plugin: register collector failed: %w", err)
+	}
+	return registered, nil
+}
+
+// unregister unregisters cols returned by register from registerer, if
+// they are not used by other plugins.
+func unregister(registerer prometheus.Registerer, cols []prometheus.Collector) {
+	registrations.mu.Lock()
+	defer registrations.mu.Unlock()
+	unregisterLocked(registerer, cols)
+}
+
+// unregisterLocked is unregister with the lock of registrations held.
+func unregisterLocked(registerer prometheus.Registerer, cols []prometheus.Collector) {
+	for _, col := range cols {
+		registrations.m[col]--
+		if registrations.m[col] > 0 {
+			continue
+		}
+		delete(registrations.m, col)
+		registerer.Unregister(col)
+	}
+}
@@ -0,0 +1,108 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gorm.io/gorm"
+)
+
+// newRegisterTestDB return a dry run db, its pool has maxOpen connections.
+func newRegisterTestDB(t *testing.T, maxOpen int) *gorm.DB {
+	db := newDryRunDB(t)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(maxOpen)
+	return db
+}
+
+// gatherValue return the value of the only series of metric name in
+// registry, and false if it is not found.
+func gatherValue(t *testing.T, registry *prometheus.Registry, name string) (float64, bool) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		if len(mf.Metric) != 1 {
+			t.Fatalf("%s has %d series, want 1", name, len(mf.Metric))
+		}
+		return metricValue(mf.Metric[0]), true
+	}
+	return 0, false
+}
+
+// metricValue return the value of a gauge or counter.
+func metricValue(m *dto.Metric) float64 {
+	if m.Gauge != nil {
+		return m.Gauge.GetValue()
+	}
+	return m.Counter.GetValue()
+}
+
+func TestRegistererReusesCollectors(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	c := Config{DBName: "db", NamePrefix: "gorm", SlowThreshold: time.Nanosecond}
+	dbs := []*gorm.DB{newRegisterTestDB(t, 3), newRegisterTestDB(t, 4)}
+	plugins := make([]MetricPlugin, len(dbs))
+	for i, db := range dbs {
+		plugins[i] = New(RegistererOption(registry), SlowQueryCallback(c), PoolStatsCallback(c))
+		if err := db.Use(plugins[i]); err != nil {
+			t.Fatalf("use plugin %d: %v", i, err)
+		}
+		db.Table("users").Find(&[]rowsModel{})
+	}
+
+	if v, _ := gatherValue(t, registry, "gorm_slow_query_count"); v != 2 {
+		t.Errorf("slow queries of both db = %v, want 2", v)
+	}
+	if v, _ := gatherValue(t, registry, "gorm_pool_max_open_connections"); v != 7 {
+		t.Errorf("max open connections of both db = %v, want 7", v)
+	}
+
+	// collectors are kept for the other plugin
+	if err := plugins[0].Close(dbs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := gatherValue(t, registry, "gorm_pool_max_open_connections"); v != 4 {
+		t.Errorf("max open connections of the open db = %v, want 4", v)
+	}
+	dbs[1].Table("users").Find(&[]rowsModel{})
+	if v, _ := gatherValue(t, registry, "gorm_slow_query_count"); v != 3 {
+		t.Errorf("slow queries after close = %v, want 3", v)
+	}
+
+	if err := plugins[1].Close(dbs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gatherValue(t, registry, "gorm_slow_query_count"); ok {
+		t.Error("collectors are registered after all plugins are closed")
+	}
+}
+
+func TestRegistererOtherDBName(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	for i, name := range []string{"db1", "db2"} {
+		c := Config{DBName: name, NamePrefix: "gorm"}
+		if err := newRegisterTestDB(t, 1).Use(New(RegistererOption(registry), SlowQueryCallback(c), PoolStatsCallback(c))); err != nil {
+			t.Fatalf("use plugin %d: %v", i, err)
+		}
+	}
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "gorm_pool_max_open_connections" && len(mf.Metric) != 2 {
+			t.Errorf("pool series = %d, want one of each db_name", len(mf.Metric))
+		}
+	}
+}
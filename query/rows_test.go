@@ -0,0 +1,55 @@
+package query
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type rowsModel struct{ ID int64 }
+
+func TestRowsReturnedOfScan(t *testing.T) {
+	db := newFakeDB(t, "")
+	rows := RowsCallback(Config{NamePrefix: "gorm"})
+	plugin := New(rows)
+	if err := db.Use(plugin); err != nil {
+		t.Fatal(err)
+	}
+
+	var dest []rowsModel
+	if err := db.WithContext(context.Background()).Raw("SELECT id FROM users").Scan(&dest).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(dest) != 3 {
+		t.Fatalf("scanned %d rows, want 3", len(dest))
+	}
+
+	// rows of db.Rows are not scanned, they are not recorded.
+	r, err := db.Raw("SELECT id FROM users").Rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(plugin.MetricsCollectors()...)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "gorm_rows_returned" {
+			continue
+		}
+		if len(mf.Metric) != 1 {
+			t.Fatalf("rows returned series = %d, want 1", len(mf.Metric))
+		}
+		h := mf.Metric[0].GetHistogram()
+		if h.GetSampleCount() != 1 || h.GetSampleSum() != 3 {
+			t.Fatalf("rows returned count = %d, sum = %v, want 1 and 3", h.GetSampleCount(), h.GetSampleSum())
+		}
+		return
+	}
+	t.Fatal("rows returned of scan not found")
+}
@@ -0,0 +1,78 @@
+package query
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// pendingRowsKey is the context key of pendingRows.
+type pendingRowsKey struct{}
+
+// pendingRows is rows returned of gorm:row, which are not scanned when
+// the callback returns. db.Scan traces the statement with scanned rows
+// after scanning, and rowsLogger records them once.
+type pendingRows struct {
+	key  metricKey
+	done int32
+}
+
+// withPendingRows puts a pendingRows of key into the statement context.
+func withPendingRows(db *gorm.DB, key metricKey) {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	db.Statement.Context = context.WithValue(ctx, pendingRowsKey{}, &pendingRows{key: key})
+}
+
+// rowsLogger wraps the logger of db, and records rows returned of
+// gorm:row when they are traced with scanned rows, until it is closed.
+type rowsLogger struct {
+	logger.Interface
+	metric *rowsMetric
+	closed *int32
+}
+
+// LogMode implements logger.Interface, the returned logger still
+// records rows, so db.Debug keeps it.
+func (l rowsLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return rowsLogger{Interface: l.Interface.LogMode(level), metric: l.metric, closed: l.closed}
+}
+
+// Trace implements logger.Interface
+func (l rowsLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	l.Interface.Trace(ctx, begin, fc, err)
+	if ctx == nil || (err != nil && err != gorm.ErrRecordNotFound) || atomic.LoadInt32(l.closed) == 1 {
+		return
+	}
+
+	p, ok := ctx.Value(pendingRowsKey{}).(*pendingRows)
+	if !ok {
+		return
+	}
+	// gorm:row traces -1 rows, they are not scanned yet.
+	if _, rows := fc(); rows >= 0 && atomic.CompareAndSwapInt32(&p.done, 0, 1) {
+		l.metric.observeReturned(p.key, rows)
+	}
+}
+
+// installRowsLogger wraps the logger of db with a rowsLogger of metric.
+// The returned restoreFunc closes it, and puts the origin one back if it
+// is not changed by others.
+func installRowsLogger(db *gorm.DB, metric *rowsMetric) restoreFunc {
+	origin := db.Config.Logger
+	l := rowsLogger{Interface: origin, metric: metric, closed: new(int32)}
+	db.Config.Logger = l
+
+	return func() error {
+		atomic.StoreInt32(l.closed, 1)
+		if current, ok := db.Config.Logger.(rowsLogger); ok && current.closed == l.closed {
+			db.Config.Logger = origin
+		}
+		return nil
+	}
+}
@@ -0,0 +1,140 @@
+package query
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxMutedTables limits cached muted results of tables, tables over it
+// are matched with MutedTables every time.
+const maxMutedTables = 10000
+
+// RuntimeConfig is the config of query plugin which can be changed at
+// runtime by Runtime.Store.
+//
+// Disabled turns off recording of all metrics. MutedTables are table
+// patterns of path.Match, statements of matched tables are not recorded.
+// SampleRate is the ratio of statements recorded by query time and rows
+// histograms, it records all of them if it is not in (0, 1). Counters,
+// slow query log and in flight queries are never sampled.
+type RuntimeConfig struct {
+	Disabled           bool
+	MutedTables        []string
+	SampleRate         float64
+	SlowThreshold      time.Duration
+	SlowThresholdRules []SlowThresholdRule
+}
+
+// Runtime holds a RuntimeConfig which can be swapped atomically. Share it
+// between Config of callbacks to change them together.
+type Runtime struct {
+	v atomic.Value
+}
+
+// runtimeState is a loaded RuntimeConfig.
+type runtimeState struct {
+	config     RuntimeConfig
+	thresholds *slowThresholds
+
+	mu    sync.RWMutex
+	muted map[string]bool
+}
+
+// NewRuntime return a Runtime with c.
+func NewRuntime(c RuntimeConfig) (*Runtime, error) {
+	r := &Runtime{}
+	if err := r.Store(c); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Load return current RuntimeConfig.
+func (r *Runtime) Load() RuntimeConfig {
+	return r.state().config
+}
+
+// Store replaces current RuntimeConfig with c. It returns an error and
+// keeps current config if any pattern of c is malformed.
+func (r *Runtime) Store(c RuntimeConfig) error {
+	for _, pattern := range c.MutedTables {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("query plugin: invalid muted table pattern %q: %w", pattern, err)
+		}
+	}
+
+	thresholds, err := newSlowThresholds(c.SlowThreshold, c.SlowThresholdRules)
+	if err != nil {
+		return err
+	}
+
+	r.v.Store(&runtimeState{config: c, thresholds: thresholds, muted: map[string]bool{}})
+	return nil
+}
+
+// emptyRuntimeState is the state of a Runtime never stored.
+var emptyRuntimeState = &runtimeState{
+	thresholds: &slowThresholds{cache: map[metricKey]time.Duration{}},
+	muted:      map[string]bool{},
+}
+
+// state return current runtimeState.
+func (r *Runtime) state() *runtimeState {
+	if s, ok := r.v.Load().(*runtimeState); ok {
+		return s
+	}
+	return emptyRuntimeState
+}
+
+// enabled return true if statements of table should be recorded.
+func (s *runtimeState) enabled(table string) bool {
+	if s.config.Disabled {
+		return false
+	}
+	if len(s.config.MutedTables) == 0 {
+		return true
+	}
+
+	s.mu.RLock()
+	muted, ok := s.muted[table]
+	s.mu.RUnlock()
+	if ok {
+		return !muted
+	}
+
+	for _, pattern := range s.config.MutedTables {
+		if muted = matchPattern(pattern, table); muted {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	if len(s.muted) < maxMutedTables {
+		s.muted[table] = muted
+	}
+	s.mu.Unlock()
+	return !muted
+}
+
+// sampled return true if a statement should be recorded by sampled
+// metrics.
+func (s *runtimeState) sampled() bool {
+	rate := s.config.SampleRate
+	return rate <= 0 || rate >= 1 || rand.Float64() < rate
+}
+
+// runtime return c.Runtime, or a new Runtime with slow thresholds of c
+// if it is nil.
+func (c Config) runtime() (*Runtime, error) {
+	if c.Runtime != nil {
+		return c.Runtime, nil
+	}
+	return NewRuntime(RuntimeConfig{
+		SlowThreshold:      c.SlowThreshold,
+		SlowThresholdRules: c.SlowThresholdRules,
+	})
+}
@@ -0,0 +1,27 @@
+package query
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMutedTablesCacheLimit(t *testing.T) {
+	runtime, err := NewRuntime(RuntimeConfig{MutedTables: []string{"logs_*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := runtime.state()
+	for i := 0; i < maxMutedTables+10; i++ {
+		table := fmt.Sprintf("logs_%d", i)
+		if state.enabled(table) {
+			t.Fatalf("%s is enabled, want muted", table)
+		}
+	}
+	if !state.enabled("users") {
+		t.Error("users over the cache limit is muted")
+	}
+	if n := len(state.muted); n != maxMutedTables {
+		t.Errorf("cached tables = %d, want %d", n, maxMutedTables)
+	}
+}
@@ -0,0 +1,195 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/changsongl/gorm-plugin/fingerprint"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// RedactedVar replaces bound vars of SlowQueryRecord when vars are redacted.
+const RedactedVar = "<redacted>"
+
+// SlowQueryRecord is a record of slow query. SQL has placeholders, and
+// Vars are the bound vars of it. Caller is the file:line calling gorm.
+// QueryName is the value of settings.QueryName. Table is the table of
+// the metric before LabelGuard, it is parsed from SQL with TableParse.
+type SlowQueryRecord struct {
+	Time         time.Time
+	QueryName    string
+	SQL          string
+	Vars         []interface{}
+	Digest       string
+	Duration     time.Duration
+	Table        string
+	Callback     string
+	RowsAffected int64
+	Caller       string
+	Err          error
+}
+
+// SlowQueryLogger receives slow query records.
+type SlowQueryLogger interface {
+	LogSlowQuery(ctx context.Context, record SlowQueryRecord)
+}
+
+// SlowQueryLogFunc is a function implemented SlowQueryLogger.
+type SlowQueryLogFunc func(ctx context.Context, record SlowQueryRecord)
+
+// LogSlowQuery implements SlowQueryLogger
+func (f SlowQueryLogFunc) LogSlowQuery(ctx context.Context, record SlowQueryRecord) {
+	f(ctx, record)
+}
+
+// SlowQueryLogOption is for logging every query over the slow threshold.
+// Vars are replaced with RedactedVar if RedactVars is true.
+type SlowQueryLogOption struct {
+	Logger     SlowQueryLogger
+	RedactVars bool
+}
+
+// log sends a record of db to the logger. table is the table of the
+// statement, it is parsed from sql if TableParse is on. The digest is
+// parsed with opts.
+func (o *SlowQueryLogOption) log(db *gorm.DB, cbName, table, queryName string, start time.Time, cost time.Duration, opts []fingerprint.Option) {
+	if o == nil || o.Logger == nil {
+		return
+	}
+
+	sql := db.Statement.SQL.String()
+	vars := make([]interface{}, len(db.Statement.Vars))
+	for i, v := range db.Statement.Vars {
+		if o.RedactVars {
+			v = RedactedVar
+		}
+		vars[i] = v
+	}
+
+	o.Logger.LogSlowQuery(db.Statement.Context, SlowQueryRecord{
+		Time:         start,
+		QueryName:    queryName,
+		SQL:          sql,
+		Vars:         vars,
+		Digest:       fingerprint.Digest(sql, opts...),
+		Duration:     cost,
+		Table:        table,
+		Callback:     cbName,
+		RowsAffected: db.RowsAffected,
+		Caller:       caller(),
+		Err:          db.Error,
+	})
+}
+
+// pluginPackagePrefix is the prefix of functions of gorm-plugin packages.
+const pluginPackagePrefix = "github.com/changsongl/gorm-plugin/"
+
+// caller return file:line of the first caller outside gorm and
+// gorm-plugin packages, so handlers of other plugins wrapping this one,
+// such as the trace plugin, are skipped too.
+func caller() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !internalFunction(frame.Function) {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// internalFunction check whether function is of gorm or gorm-plugin.
+func internalFunction(function string) bool {
+	return strings.HasPrefix(function, "gorm.io/") || strings.HasPrefix(function, pluginPackagePrefix)
+}
+
+// jsonSlowQueryLogger writes slow query records as JSON lines.
+type jsonSlowQueryLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// jsonSlowQueryRecord is the JSON format of SlowQueryRecord.
+type jsonSlowQueryRecord struct {
+	Time         time.Time     `json:"time"`
+	QueryName    string        `json:"query_name,omitempty"`
+	SQL          string        `json:"sql"`
+	Vars         []interface{} `json:"vars"`
+	Digest       string        `json:"digest"`
+	DurationMs   float64       `json:"duration_ms"`
+	Table        string        `json:"table"`
+	Callback     string        `json:"callback"`
+	RowsAffected int64         `json:"rows_affected"`
+	Caller       string        `json:"caller"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// NewJSONSlowQueryLogger return a SlowQueryLogger writing a JSON line
+// to w for every record.
+func NewJSONSlowQueryLogger(w io.Writer) SlowQueryLogger {
+	return &jsonSlowQueryLogger{w: w}
+}
+
+// LogSlowQuery implements SlowQueryLogger
+func (l *jsonSlowQueryLogger) LogSlowQuery(_ context.Context, record SlowQueryRecord) {
+	r := jsonSlowQueryRecord{
+		Time:         record.Time,
+		QueryName:    record.QueryName,
+		SQL:          record.SQL,
+		Vars:         record.Vars,
+		Digest:       record.Digest,
+		DurationMs:   float64(record.Duration) / float64(time.Millisecond),
+		Table:        record.Table,
+		Callback:     record.Callback,
+		RowsAffected: record.RowsAffected,
+		Caller:       record.Caller,
+	}
+	if record.Err != nil {
+		r.Error = record.Err.Error()
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		// vars may not be marshalled, use their string values instead.
+		r.Vars = make([]interface{}, len(record.Vars))
+		for i, v := range record.Vars {
+			r.Vars[i] = fmt.Sprint(v)
+		}
+		if line, err = json.Marshal(r); err != nil {
+			return
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(append(line, '\n'))
+}
+
+// gormSlowQueryLogger writes slow query records to gorm logger.
+type gormSlowQueryLogger struct {
+	l logger.Interface
+}
+
+// NewGormSlowQueryLogger return a SlowQueryLogger writing records to
+// gorm logger in warn level.
+func NewGormSlowQueryLogger(l logger.Interface) SlowQueryLogger {
+	return gormSlowQueryLogger{l: l}
+}
+
+// LogSlowQuery implements SlowQueryLogger
+func (g gormSlowQueryLogger) LogSlowQuery(ctx context.Context, record SlowQueryRecord) {
+	g.l.Warn(ctx, "slow query: %s vars=%v duration=%s table=%s callback=%s rows=%d digest=%s name=%s caller=%s error=%v",
+		record.SQL, record.Vars, record.Duration, record.Table, record.Callback,
+		record.RowsAffected, record.Digest, record.QueryName, record.Caller, record.Err)
+}
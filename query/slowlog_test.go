@@ -0,0 +1,82 @@
+package query
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowQueryLogParsedTable(t *testing.T) {
+	db := newDryRunDB(t)
+
+	var records []SlowQueryRecord
+	plugin := New(SlowQueryCallback(Config{
+		NamePrefix:    "gorm",
+		SlowThreshold: time.Nanosecond,
+		TableParse:    TableParseFirst,
+		SlowQueryLog: &SlowQueryLogOption{Logger: SlowQueryLogFunc(func(ctx context.Context, record SlowQueryRecord) {
+			records = append(records, record)
+		})},
+	}))
+	if err := db.Use(plugin); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Exec("UPDATE orders SET state = ? WHERE id = ?", 1, 2)
+	if len(records) != 1 {
+		t.Fatalf("got %d slow query records, want 1", len(records))
+	}
+	if records[0].Table != "orders" {
+		t.Errorf("table of slow query record = %q, want orders", records[0].Table)
+	}
+}
+
+func TestInternalFunction(t *testing.T) {
+	for function, want := range map[string]bool{
+		"gorm.io/gorm.(*DB).Find":                                             true,
+		"github.com/changsongl/gorm-plugin/query.caller":                      true,
+		"github.com/changsongl/gorm-plugin/trace.(*tracer).interceptor.func1": true,
+		"main.main":                          false,
+		"example.com/app/repo.(*Users).Find": false,
+	} {
+		if got := internalFunction(function); got != want {
+			t.Errorf("internalFunction(%q) = %v, want %v", function, got, want)
+		}
+	}
+}
+
+func TestSlowQueryLogCallerSkipsWrappers(t *testing.T) {
+	db := newDryRunDB(t)
+
+	var records []SlowQueryRecord
+	slow := New(SlowQueryCallback(Config{
+		NamePrefix:    "gorm",
+		SlowThreshold: time.Nanosecond,
+		SlowQueryLog: &SlowQueryLogOption{Logger: SlowQueryLogFunc(func(ctx context.Context, record SlowQueryRecord) {
+			records = append(records, record)
+		})},
+	}))
+	// a plugin wrapping the slow query handler, such as the trace plugin.
+	outer := NewWithName("outer", InterceptorCallback(countInterceptor(map[string]int{})))
+	for _, plugin := range []MetricPlugin{slow, outer} {
+		if err := db.Use(plugin); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.Table("users").Find(&[]rowsModel{})
+	if len(records) != 1 {
+		t.Fatalf("got %d slow query records, want 1", len(records))
+	}
+
+	// functions of this module are all skipped, so the caller is the
+	// test runner.
+	_, file, _, _ := runtime.Caller(0)
+	root := filepath.Dir(filepath.Dir(file))
+	if caller := records[0].Caller; caller == "" || strings.HasPrefix(caller, root) {
+		t.Errorf("caller = %q, want one outside %s", caller, root)
+	}
+}
@@ -0,0 +1,47 @@
+package query
+
+import (
+	"github.com/changsongl/gorm-plugin/settings"
+	"gorm.io/gorm"
+)
+
+// settingsKey is the setting key of settings loaded by interceptors.
+const settingsKey = "gorm-plugin:metric:settings"
+
+// loadedSettings are settings of stmt loaded for a callback, they are
+// shared by interceptors of the callback. Settings of a statement are
+// copied by its clones, so stmt tells whose settings they are.
+type loadedSettings struct {
+	stmt     *gorm.Statement
+	settings settings.Settings
+	stale    bool
+}
+
+// statementSettings return settings of the statement of db. They are
+// loaded once for every callback of the statement, instead of once for
+// every interceptor.
+func statementSettings(db *gorm.DB) settings.Settings {
+	v, _ := db.Statement.Settings.Load(settingsKey)
+	loaded, ok := v.(*loadedSettings)
+	if ok && loaded.stmt == db.Statement {
+		if loaded.stale {
+			loaded.settings, loaded.stale = settings.Load(db), false
+		}
+		return loaded.settings
+	}
+
+	s := settings.Load(db)
+	db.Statement.Settings.Store(settingsKey, &loadedSettings{stmt: db.Statement, settings: s})
+	return s
+}
+
+// resetStatementSettings marks settings loaded by a previous callback of
+// the statement stale. A statement may be executed again with changed
+// settings, so it is called when a callback starts.
+func resetStatementSettings(db *gorm.DB) {
+	if v, ok := db.Statement.Settings.Load(settingsKey); ok {
+		if loaded, ok := v.(*loadedSettings); ok && loaded.stmt == db.Statement {
+			loaded.stale = true
+		}
+	}
+}
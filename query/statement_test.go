@@ -0,0 +1,65 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/changsongl/gorm-plugin/settings"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+func TestStatementSettings(t *testing.T) {
+	db := &gorm.DB{Statement: &gorm.Statement{}}
+	db.Statement.Settings.Store(settings.QueryName, "a")
+	if s := statementSettings(db); s.QueryName != "a" {
+		t.Fatalf("query name = %q, want a", s.QueryName)
+	}
+
+	// settings are loaded once for a callback.
+	db.Statement.Settings.Store(settings.QueryName, "b")
+	if s := statementSettings(db); s.QueryName != "a" {
+		t.Errorf("query name = %q, want a loaded for the callback", s.QueryName)
+	}
+	resetStatementSettings(db)
+	if s := statementSettings(db); s.QueryName != "b" {
+		t.Errorf("query name = %q after reset, want b", s.QueryName)
+	}
+
+	// a clone copies loaded settings, but loads its own.
+	clone := &gorm.DB{Statement: &gorm.Statement{}}
+	db.Statement.Settings.Range(func(k, v interface{}) bool {
+		clone.Statement.Settings.Store(k, v)
+		return true
+	})
+	clone.Statement.Settings.Store(settings.QueryName, "c")
+	if s := statementSettings(clone); s.QueryName != "c" {
+		t.Errorf("query name of clone = %q, want c", s.QueryName)
+	}
+	if s := statementSettings(db); s.QueryName != "b" {
+		t.Errorf("query name = %q after clone loaded, want b", s.QueryName)
+	}
+}
+
+func TestStatementSettingsOfReusedStatement(t *testing.T) {
+	for name, mode := range map[string]Mode{"replace": ReplaceMode, "hook": HookMode} {
+		t.Run(name, func(t *testing.T) { testStatementSettingsOfReusedStatement(t, mode) })
+	}
+}
+
+func testStatementSettingsOfReusedStatement(t *testing.T, mode Mode) {
+	db := newDryRunDB(t)
+	registry := prometheus.NewRegistry()
+	c := Config{NamePrefix: "gorm", SlowThreshold: time.Nanosecond, Mode: mode}
+	if err := db.Use(New(RegistererOption(registry), SlowQueryCallback(c))); err != nil {
+		t.Fatal(err)
+	}
+
+	// tx is not a new session, its statement is reused by every Exec.
+	tx := db.Table("users")
+	tx.Exec("UPDATE users SET name = ?", "bob")
+	tx.Set(settings.SkipMetrics, true).Exec("UPDATE users SET name = ?", "bob")
+	if v, _ := gatherValue(t, registry, "gorm_slow_query_count"); v != 1 {
+		t.Errorf("slow queries = %v, want 1 of the statement not skipped", v)
+	}
+}
@@ -0,0 +1,82 @@
+package query
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/changsongl/gorm-plugin/fingerprint"
+	"gorm.io/gorm"
+)
+
+// maxParsedSQL limits cached table names of sql, sql over it is parsed
+// every time.
+const maxParsedSQL = 10000
+
+// TableParseMode is the way to get table names from SQL of statements
+// without Statement.Table, such as db.Raw and db.Exec.
+type TableParseMode int
+
+const (
+	// TableParseOff keeps table_name empty. It is the default mode.
+	TableParseOff TableParseMode = iota
+
+	// TableParseFirst uses the first target of FROM, JOIN, INTO or
+	// UPDATE.
+	TableParseFirst
+
+	// TableParseAll uses all tables joined by ",", in order of
+	// appearance.
+	TableParseAll
+)
+
+// tableParser parses table names from SQL, results are cached by SQL.
+type tableParser struct {
+	mode TableParseMode
+	opts []fingerprint.Option
+
+	mu    sync.RWMutex
+	cache map[string]string
+}
+
+// newTableParser return a tableParser of mode parsing SQL with opts, it
+// is nil if mode is TableParseOff.
+func newTableParser(mode TableParseMode, opts ...fingerprint.Option) *tableParser {
+	if mode == TableParseOff {
+		return nil
+	}
+	return &tableParser{mode: mode, opts: opts, cache: map[string]string{}}
+}
+
+// table return Statement.Table of db, or the table parsed from
+// Statement.SQL if it is empty. It is safe on nil.
+func (p *tableParser) table(db *gorm.DB) string {
+	if p == nil || db.Statement.Table != "" {
+		return db.Statement.Table
+	}
+
+	sql := db.Statement.SQL.String()
+	if sql == "" {
+		return ""
+	}
+
+	p.mu.RLock()
+	table, ok := p.cache[sql]
+	p.mu.RUnlock()
+	if ok {
+		return table
+	}
+
+	if tables := fingerprint.Tables(sql, p.opts...); len(tables) > 0 {
+		table = tables[0]
+		if p.mode == TableParseAll {
+			table = strings.Join(tables, ",")
+		}
+	}
+
+	p.mu.Lock()
+	if len(p.cache) < maxParsedSQL {
+		p.cache[sql] = table
+	}
+	p.mu.Unlock()
+	return table
+}
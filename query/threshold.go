@@ -0,0 +1,89 @@
+package query
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+// maxCachedThresholds limits cached slow thresholds of tables and
+// callbacks, others are matched with rules every time.
+const maxCachedThresholds = 10000
+
+// SlowThresholdRule is a slow threshold for statements whose table and
+// callback match Table and Callback. They are patterns of path.Match,
+// like "orders_*" or "gorm:*", empty pattern matches everything.
+type SlowThresholdRule struct {
+	Table     string
+	Callback  string
+	Threshold time.Duration
+}
+
+// match return true if table and callback match the rule.
+func (r SlowThresholdRule) match(table, cbName string) bool {
+	return matchPattern(r.Table, table) && matchPattern(r.Callback, cbName)
+}
+
+// matchPattern match name with pattern, empty pattern matches everything.
+func matchPattern(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// slowThresholds finds the slow threshold of table and callback. The first
+// matched rule wins, and the default threshold is used if no rule matches.
+type slowThresholds struct {
+	def   time.Duration
+	rules []SlowThresholdRule
+
+	mu    sync.RWMutex
+	cache map[metricKey]time.Duration
+}
+
+// newSlowThresholds return a slowThresholds, it returns an error if
+// any pattern is malformed.
+func newSlowThresholds(def time.Duration, rules []SlowThresholdRule) (*slowThresholds, error) {
+	for _, r := range rules {
+		for _, pattern := range []string{r.Table, r.Callback} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("query plugin: invalid slow threshold pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	return &slowThresholds{def: def, rules: rules, cache: map[metricKey]time.Duration{}}, nil
+}
+
+// get return the slow threshold of table and callback.
+func (t *slowThresholds) get(table, cbName string) time.Duration {
+	if len(t.rules) == 0 {
+		return t.def
+	}
+
+	key := metricKey{table: table, callback: cbName}
+	t.mu.RLock()
+	threshold, ok := t.cache[key]
+	t.mu.RUnlock()
+	if ok {
+		return threshold
+	}
+
+	threshold = t.def
+	for _, r := range t.rules {
+		if r.match(table, cbName) {
+			threshold = r.Threshold
+			break
+		}
+	}
+
+	t.mu.Lock()
+	if len(t.cache) < maxCachedThresholds {
+		t.cache[key] = threshold
+	}
+	t.mu.Unlock()
+	return threshold
+}
@@ -0,0 +1,29 @@
+package query
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSlowThresholdsCacheLimit(t *testing.T) {
+	thresholds, err := newSlowThresholds(time.Second, []SlowThresholdRule{
+		{Table: "orders_*", Threshold: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxCachedThresholds+10; i++ {
+		table := fmt.Sprintf("orders_%d", i)
+		if got := thresholds.get(table, "gorm:query"); got != time.Millisecond {
+			t.Fatalf("threshold of %s = %v, want 1ms", table, got)
+		}
+	}
+	if got := thresholds.get("users", "gorm:query"); got != time.Second {
+		t.Errorf("threshold of users over the cache limit = %v, want 1s", got)
+	}
+	if n := len(thresholds.cache); n != maxCachedThresholds {
+		t.Errorf("cached thresholds = %d, want %d", n, maxCachedThresholds)
+	}
+}
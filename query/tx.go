@@ -0,0 +1,254 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// transaction outcomes
+const (
+	TxCommit       = "commit"
+	TxCommitFailed = "commit_failed"
+	TxRollback     = "rollback"
+)
+
+// labelOutcome is the label of transaction outcome
+const labelOutcome = "outcome"
+
+// DefaultTxStatementsBuckets is the default buckets of statements per
+// transaction histogram.
+var DefaultTxStatementsBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 500, 1000}
+
+// txMetric has transaction duration and statements histograms, and
+// transaction counter by outcome.
+type txMetric struct {
+	duration   HistogramVec
+	statements HistogramVec
+	counter    CounterVec
+
+	once               sync.Once
+	durationObservers  map[string]Observer
+	statementObservers map[string]Observer
+	counters           map[string]Counter
+}
+
+// newTxMetric return a txMetric
+func newTxMetric(meter Meter, namePrefix, namespace, dbName string, buckets, statementsBuckets []float64) *txMetric {
+	labels := []string{labelOutcome}
+	return &txMetric{
+		duration: meter.NewHistogram(HistogramOpts{
+			MetricOpts: MetricOpts{
+				Name:        fmt.Sprintf("%s_tx_duration", namePrefix),
+				Namespace:   namespace,
+				Help:        "gorm-plugin: transaction duration histogram (unit: second)",
+				Unit:        "s",
+				ConstLabels: getDBConstLabel(dbName),
+				Labels:      labels,
+			},
+			Buckets: buckets,
+		}),
+		statements: meter.NewHistogram(HistogramOpts{
+			MetricOpts: MetricOpts{
+				Name:        fmt.Sprintf("%s_tx_statements", namePrefix),
+				Namespace:   namespace,
+				Help:        "gorm-plugin: statements per transaction histogram",
+				ConstLabels: getDBConstLabel(dbName),
+				Labels:      labels,
+			},
+			Buckets: statementsBuckets,
+		}),
+		counter: meter.NewCounter(MetricOpts{
+			Name:        fmt.Sprintf("%s_tx_count", namePrefix),
+			Namespace:   namespace,
+			Help:        "gorm-plugin: transaction counter by outcome",
+			ConstLabels: getDBConstLabel(dbName),
+			Labels:      labels,
+		}),
+	}
+}
+
+// init creates metrics of outcomes. They are known, so they are created
+// once, after collectors are registered.
+func (m *txMetric) init() {
+	m.durationObservers = map[string]Observer{}
+	m.statementObservers = map[string]Observer{}
+	m.counters = map[string]Counter{}
+	for _, outcome := range []string{TxCommit, TxCommitFailed, TxRollback} {
+		m.durationObservers[outcome] = m.duration.WithLabelValues(outcome)
+		m.statementObservers[outcome] = m.statements.WithLabelValues(outcome)
+		m.counters[outcome] = m.counter.WithLabelValues(outcome)
+	}
+}
+
+// observe records a finished transaction.
+func (m *txMetric) observe(outcome string, cost time.Duration, statements int64) {
+	m.once.Do(m.init)
+	m.durationObservers[outcome].Observe(float64(cost) / float64(time.Second))
+	m.statementObservers[outcome].Observe(float64(statements))
+	m.counters[outcome].Inc()
+}
+
+// txTracker observes transactions begun on a txConnPool.
+type txTracker struct {
+	runtime  *Runtime
+	metric   *txMetric
+	detector *longTxDetector
+	now      func() time.Time
+}
+
+// begin return a txConn wrapping tx.
+func (t *txTracker) begin(tx gorm.ConnPool) *txConn {
+	conn := &txConn{ConnPool: tx, tracker: t, start: t.now()}
+	t.detector.add(conn)
+	return conn
+}
+
+// end records tx with outcome.
+func (t *txTracker) end(tx *txConn, outcome string) {
+	t.detector.remove(tx)
+	if t.runtime.state().config.Disabled {
+		return
+	}
+	t.metric.observe(outcome, t.now().Sub(tx.start), atomic.LoadInt64(&tx.statements))
+}
+
+// txConnPool wraps the ConnPool of a db, transactions begun on it are
+// wrapped by txConn until it is closed.
+type txConnPool struct {
+	gorm.ConnPool
+	tracker *txTracker
+	closed  int32
+}
+
+// BeginTx implements gorm.ConnPoolBeginner
+func (p *txConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	var tx gorm.ConnPool
+	switch beginner := p.ConnPool.(type) {
+	case gorm.TxBeginner:
+		sqlTx, err := beginner.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		tx = sqlTx
+	case gorm.ConnPoolBeginner:
+		connPool, err := beginner.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		tx = connPool
+	default:
+		return nil, gorm.ErrInvalidTransaction
+	}
+
+	if atomic.LoadInt32(&p.closed) == 1 {
+		return tx, nil
+	}
+	return p.tracker.begin(tx), nil
+}
+
+// GetDBConn implements gorm.GetDBConnector
+func (p *txConnPool) GetDBConn() (*sql.DB, error) {
+	if connector, ok := p.ConnPool.(gorm.GetDBConnector); ok && connector != nil {
+		return connector.GetDBConn()
+	}
+	if sqlDB, ok := p.ConnPool.(*sql.DB); ok {
+		return sqlDB, nil
+	}
+	return nil, gorm.ErrInvalidDB
+}
+
+// txConn wraps a transaction, it counts statements executed in it, and
+// records it when it is committed or rolled back.
+type txConn struct {
+	gorm.ConnPool
+	tracker *txTracker
+	start   time.Time
+
+	statements int64
+	ended      int32
+}
+
+// ExecContext implements gorm.ConnPool
+func (tx *txConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	atomic.AddInt64(&tx.statements, 1)
+	return tx.ConnPool.ExecContext(ctx, query, args...)
+}
+
+// QueryContext implements gorm.ConnPool
+func (tx *txConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	atomic.AddInt64(&tx.statements, 1)
+	return tx.ConnPool.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext implements gorm.ConnPool
+func (tx *txConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	atomic.AddInt64(&tx.statements, 1)
+	return tx.ConnPool.QueryRowContext(ctx, query, args...)
+}
+
+// Commit implements gorm.TxCommitter
+func (tx *txConn) Commit() error {
+	committer, ok := tx.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+
+	err := committer.Commit()
+	outcome := TxCommit
+	if err != nil {
+		outcome = TxCommitFailed
+	}
+	tx.end(outcome)
+	return err
+}
+
+// Rollback implements gorm.TxCommitter
+func (tx *txConn) Rollback() error {
+	committer, ok := tx.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+
+	err := committer.Rollback()
+	tx.end(TxRollback)
+	return err
+}
+
+// end records the transaction once, gorm may roll back a transaction
+// after its commit failed.
+func (tx *txConn) end(outcome string) {
+	if atomic.CompareAndSwapInt32(&tx.ended, 0, 1) {
+		tx.tracker.end(tx, outcome)
+	}
+}
+
+// installTxConnPool wraps the ConnPool of db statement with a
+// txConnPool of tracker. The returned restoreFunc closes it, and puts the
+// origin one back if it is not changed by others.
+func installTxConnPool(db *gorm.DB, tracker *txTracker) (restoreFunc, error) {
+	origin := db.Statement.ConnPool
+	if origin == nil {
+		return nil, fmt.Errorf("query plugin: conn pool of db not found")
+	}
+	if _, ok := origin.(*txConnPool); ok {
+		return nil, fmt.Errorf("query plugin: transaction callback is installed")
+	}
+
+	pool := &txConnPool{ConnPool: origin, tracker: tracker}
+	db.Statement.ConnPool = pool
+
+	restore := func() error {
+		atomic.StoreInt32(&pool.closed, 1)
+		if db.Statement.ConnPool == gorm.ConnPool(pool) {
+			db.Statement.ConnPool = origin
+		}
+		return nil
+	}
+	return restore, nil
+}
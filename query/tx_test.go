@@ -0,0 +1,212 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/changsongl/gorm-plugin/internal/fakedriver"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gorm.io/gorm"
+)
+
+// applyTxCallback applies a TransactionCallback on db, and return the
+// registry of its metrics.
+func applyTxCallback(t *testing.T, db *gorm.DB) (*prometheus.Registry, restoreFunc) {
+	cb := TransactionCallback(Config{NamePrefix: "gorm"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(cb.getCollector()...)
+	restore, err := cb.apply(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry, restore
+}
+
+// txSeries return series of the transaction metric name by outcome.
+func txSeries(t *testing.T, registry *prometheus.Registry, name string) map[string]*dto.Metric {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := map[string]*dto.Metric{}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.Metric {
+			for _, l := range m.Label {
+				if l.GetName() == labelOutcome {
+					series[l.GetValue()] = m
+				}
+			}
+		}
+	}
+	return series
+}
+
+// checkTx checks only one transaction is recorded, it is of outcome with
+// statements.
+func checkTx(t *testing.T, registry *prometheus.Registry, outcome string, statements float64) {
+	t.Helper()
+	for o, m := range txSeries(t, registry, "gorm_tx_count") {
+		want := 0.0
+		if o == outcome {
+			want = 1
+		}
+		if v := m.Counter.GetValue(); v != want {
+			t.Errorf("%s transactions = %v, want %v", o, v, want)
+		}
+	}
+	m, ok := txSeries(t, registry, "gorm_tx_statements")[outcome]
+	if !ok {
+		t.Fatalf("statements of %s transactions not found", outcome)
+	}
+	if h := m.GetHistogram(); h.GetSampleCount() != 1 || h.GetSampleSum() != statements {
+		t.Errorf("statements count = %d, sum = %v, want 1 and %v", h.GetSampleCount(), h.GetSampleSum(), statements)
+	}
+}
+
+func TestTransactionOutcomes(t *testing.T) {
+	errAbort := errors.New("abort")
+	cases := []struct {
+		name       string
+		dsn        string
+		fn         func(tx *gorm.DB) error
+		outcome    string
+		statements float64
+	}{
+		{
+			name: "commit",
+			fn: func(tx *gorm.DB) error {
+				tx.Exec("UPDATE users SET name = ?", "bob")
+				tx.Exec("UPDATE users SET age = ?", 18)
+				return tx.Raw("SELECT id FROM users").Scan(&[]rowsModel{}).Error
+			},
+			outcome:    TxCommit,
+			statements: 3,
+		},
+		{
+			name: "rollback",
+			fn: func(tx *gorm.DB) error {
+				tx.Exec("UPDATE users SET name = ?", "bob")
+				return errAbort
+			},
+			outcome:    TxRollback,
+			statements: 1,
+		},
+		{
+			// gorm rolls back the transaction after its commit failed,
+			// it is recorded once.
+			name: "commit failed",
+			dsn:  fakedriver.CommitFailedDSN,
+			fn: func(tx *gorm.DB) error {
+				return tx.Exec("UPDATE users SET name = ?", "bob").Error
+			},
+			outcome:    TxCommitFailed,
+			statements: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := newFakeDB(t, c.dsn)
+			registry, restore := applyTxCallback(t, db)
+			defer restore()
+
+			if err := db.Transaction(c.fn); (err == nil) != (c.outcome == TxCommit) {
+				t.Fatalf("transaction error = %v, want %s", err, c.outcome)
+			}
+			checkTx(t, registry, c.outcome, c.statements)
+		})
+	}
+}
+
+func TestTxConnPoolOfConnPoolBeginner(t *testing.T) {
+	db := &gorm.DB{Config: &gorm.Config{}, Statement: &gorm.Statement{ConnPool: fakePool{}}}
+	registry, restore := applyTxCallback(t, db)
+	defer restore()
+
+	tx, err := db.Statement.ConnPool.(gorm.ConnPoolBeginner).BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tx.(*txConn); !ok {
+		t.Fatalf("transaction is %T, want *txConn", tx)
+	}
+	tx.ExecContext(context.Background(), "UPDATE users SET name = ?")
+	tx.ExecContext(context.Background(), "UPDATE users SET age = ?")
+	if err := tx.(gorm.TxCommitter).Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.(gorm.TxCommitter).Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	checkTx(t, registry, TxCommit, 2)
+}
+
+func TestTxConnPoolOfInvalidPool(t *testing.T) {
+	pool := &txConnPool{ConnPool: struct{ gorm.ConnPool }{}}
+	if _, err := pool.BeginTx(context.Background(), nil); err != gorm.ErrInvalidTransaction {
+		t.Errorf("error = %v, want %v", err, gorm.ErrInvalidTransaction)
+	}
+}
+
+func TestInstallTxConnPool(t *testing.T) {
+	db := &gorm.DB{Config: &gorm.Config{}, Statement: &gorm.Statement{ConnPool: fakePool{}}}
+	registry, restore := applyTxCallback(t, db)
+	if _, err := TransactionCallback(Config{NamePrefix: "gorm"}).apply(db); err == nil ||
+		!strings.Contains(err.Error(), "transaction callback is installed") {
+		t.Errorf("error = %v, want installed", err)
+	}
+
+	if err := restore(); err != nil {
+		t.Fatal(err)
+	}
+	if db.Statement.ConnPool != gorm.ConnPool(fakePool{}) {
+		t.Errorf("conn pool = %T after restore, want the origin one", db.Statement.ConnPool)
+	}
+
+	// a pool wrapping ours is kept after restore, ours is closed, so
+	// transactions begun on it are not recorded.
+	registry, restore = applyTxCallback(t, db)
+	pool := db.Statement.ConnPool.(*txConnPool)
+	wrapper := struct{ gorm.ConnPool }{pool}
+	db.Statement.ConnPool = wrapper
+	if err := restore(); err != nil {
+		t.Fatal(err)
+	}
+	if db.Statement.ConnPool != gorm.ConnPool(wrapper) {
+		t.Errorf("conn pool = %T after restore, want the wrapper", db.Statement.ConnPool)
+	}
+	tx, err := pool.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tx.(*txConn); ok {
+		t.Fatal("transaction of closed pool is wrapped")
+	}
+	if len(txSeries(t, registry, "gorm_tx_count")) != 0 {
+		t.Error("transaction of closed pool is recorded")
+	}
+}
+
+func TestTransactionCallbackIgnoresExtraLabels(t *testing.T) {
+	db := newDryRunDB(t)
+	c := Config{NamePrefix: "gorm", ExtraLabels: []string{"bad-label"}}
+	plugin := New(RegistererOption(prometheus.NewRegistry()), TransactionCallback(c))
+	if err := db.Use(plugin); err != nil {
+		t.Fatalf("Initialize failed with extra labels ignored by transactions: %v", err)
+	}
+	if err := plugin.Close(db); err != nil {
+		t.Fatal(err)
+	}
+
+	c = Config{NamePrefix: "gorm", Buckets: []float64{2, 1}}
+	err := newDryRunDB(t).Use(New(RegistererOption(prometheus.NewRegistry()), TransactionCallback(c)))
+	if err == nil || !strings.Contains(err.Error(), "buckets [2 1] are not in increasing order") {
+		t.Errorf("error = %v, want invalid buckets", err)
+	}
+}
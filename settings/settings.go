@@ -0,0 +1,91 @@
+// Package settings defines per statement setting keys honored by gorm-plugin
+// plugins. Set them by db.Set or db.InstanceSet:
+//
+//	db.Set(settings.SkipMetrics, true).Raw("SELECT 1").Scan(&n)
+//	db.Set(settings.SlowThreshold, 5*time.Second).Find(&report)
+package settings
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// setting keys
+const (
+	// SkipMetrics skips metrics of the query plugin, value is bool.
+	SkipMetrics = "gorm-plugin:skip_metrics"
+	// SlowThreshold overrides the slow threshold of the query plugin,
+	// value is time.Duration.
+	SlowThreshold = "gorm-plugin:slow_threshold"
+	// SkipExplain skips the explain plugin, value is bool.
+	SkipExplain = "gorm-plugin:skip_explain"
+	// QueryName is a logical name of the statement, value is string.
+	QueryName = "gorm-plugin:query_name"
+)
+
+// keyPrefix is the prefix of setting keys.
+const keyPrefix = "gorm-plugin:"
+
+// Settings are the values of setting keys of a statement.
+type Settings struct {
+	SkipMetrics   bool
+	SlowThreshold time.Duration
+	SkipExplain   bool
+	QueryName     string
+}
+
+// Load return settings of db.Statement. Values set by db.InstanceSet
+// win over values set by db.Set.
+func Load(db *gorm.DB) Settings {
+	var s Settings
+	if db == nil || db.Statement == nil {
+		return s
+	}
+
+	var instancePrefix string
+	var instance []interface{}
+	db.Statement.Settings.Range(func(k, v interface{}) bool {
+		key, ok := k.(string)
+		if !ok {
+			return true
+		}
+
+		idx := strings.Index(key, keyPrefix)
+		switch {
+		case idx < 0:
+		case idx == 0:
+			s.set(key, v)
+		default:
+			// instance settings are prefixed with the statement pointer.
+			if instancePrefix == "" {
+				instancePrefix = fmt.Sprintf("%p", db.Statement)
+			}
+			if key[:idx] == instancePrefix {
+				instance = append(instance, key[idx:], v)
+			}
+		}
+		return true
+	})
+
+	for i := 0; i < len(instance); i += 2 {
+		s.set(instance[i].(string), instance[i+1])
+	}
+	return s
+}
+
+// set value of key if the key is a setting key.
+func (s *Settings) set(key string, v interface{}) {
+	switch key {
+	case SkipMetrics:
+		s.SkipMetrics, _ = v.(bool)
+	case SlowThreshold:
+		s.SlowThreshold, _ = v.(time.Duration)
+	case SkipExplain:
+		s.SkipExplain, _ = v.(bool)
+	case QueryName:
+		s.QueryName, _ = v.(string)
+	}
+}
@@ -0,0 +1,186 @@
+package trace
+
+import (
+	"context"
+	"time"
+
+	"github.com/changsongl/gorm-plugin/explain"
+	"github.com/changsongl/gorm-plugin/fingerprint"
+	"github.com/changsongl/gorm-plugin/query"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// tracerName is the instrumentation name of the tracer
+const tracerName = "github.com/changsongl/gorm-plugin/trace"
+
+// span attribute keys
+const (
+	keyDBSystem     = attribute.Key("db.system")
+	keyDBStatement  = attribute.Key("db.statement")
+	keyDBTable      = attribute.Key("db.sql.table")
+	keyRowsAffected = attribute.Key("db.rows_affected")
+	keyCallback     = attribute.Key("gorm.callback")
+)
+
+// explain event name and attribute keys
+const (
+	explainEvent           = "explain"
+	keyExplainSQL          = attribute.Key("explain.sql")
+	keyExplainRecommend    = attribute.Key("explain.recommendation")
+	keyExplainID           = attribute.Key("explain.id")
+	keyExplainSelectType   = attribute.Key("explain.select_type")
+	keyExplainTable        = attribute.Key("explain.table")
+	keyExplainType         = attribute.Key("explain.type")
+	keyExplainPossibleKeys = attribute.Key("explain.possible_keys")
+	keyExplainKey          = attribute.Key("explain.key")
+	keyExplainRows         = attribute.Key("explain.rows")
+	keyExplainExtra        = attribute.Key("explain.extra")
+	keyExplainFingerprint  = attribute.Key("explain.fingerprint")
+	keyExplainDigest       = attribute.Key("explain.digest")
+	keyExplainQueryName    = attribute.Key("explain.query_name")
+	keyExplainResults      = attribute.Key("explain.results")
+)
+
+// tracer starts spans of gorm statements.
+type tracer struct {
+	tracer    oteltrace.Tracer
+	dbSystem  string
+	sanitize  bool
+	explainer *explain.StatementExplainer
+
+	fingerprintOpts []fingerprint.Option
+}
+
+// newTracer return a tracer of opts
+func newTracer(opts *options) *tracer {
+	provider := opts.provider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	return &tracer{
+		tracer:    provider.Tracer(tracerName),
+		dbSystem:  opts.dbSystem,
+		sanitize:  opts.sanitize,
+		explainer: opts.explainer,
+
+		fingerprintOpts: opts.fingerprintOpts,
+	}
+}
+
+// interceptor starts a span from the statement context before the next
+// Handler, and ends it after. The span is in the statement context while
+// the next Handler runs, values it adds to the context are kept after.
+func (t *tracer) interceptor(cbName string) func(next query.Handler) query.Handler {
+	return func(next query.Handler) query.Handler {
+		return func(db *gorm.DB) {
+			origin := db.Statement.Context
+			ctx, span := t.tracer.Start(origin, cbName,
+				oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+				oteltrace.WithTimestamp(query.StartTime(db)),
+			)
+
+			db.Statement.Context = ctx
+			next(db)
+			end := time.Now()
+			db.Statement.Context = restoreContext(db.Statement.Context, ctx, origin)
+
+			t.end(span, db, cbName, end)
+		}
+	}
+}
+
+// restoreContext return the statement context after the span of ctx
+// ends. It is origin if current is still ctx. Otherwise the next Handler
+// added values to it, such as rows of gorm:row which are scanned later,
+// so they are kept, and only the span is replaced by the one of origin.
+func restoreContext(current, ctx, origin context.Context) context.Context {
+	if current == ctx {
+		return origin
+	}
+	return oteltrace.ContextWithSpan(current, oteltrace.SpanFromContext(origin))
+}
+
+// end sets attributes and errors of the statement to span, and ends it
+// at end, so explaining the statement is not in the span duration.
+func (t *tracer) end(span oteltrace.Span, db *gorm.DB, cbName string, end time.Time) {
+	defer span.End(oteltrace.WithTimestamp(end))
+	if !span.IsRecording() {
+		return
+	}
+
+	system := t.dbSystem
+	if system == "" && db.Dialector != nil {
+		system = db.Dialector.Name()
+	}
+
+	sql := db.Statement.SQL.String()
+	if t.sanitize {
+		sql = fingerprint.Normalize(sql, t.fingerprintOpts...)
+	}
+
+	table := db.Statement.Table
+	if table == "" {
+		if tables := fingerprint.Tables(sql, t.fingerprintOpts...); len(tables) > 0 {
+			table = tables[0]
+		}
+	}
+
+	span.SetAttributes(
+		keyDBSystem.String(system),
+		keyDBStatement.String(sql),
+		keyDBTable.String(table),
+		keyRowsAffected.Int64(db.RowsAffected),
+		keyCallback.String(cbName),
+	)
+
+	if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+		return
+	}
+
+	if t.explainer != nil {
+		if result, ok := t.explainer.Explain(db); ok {
+			addExplainEvents(span, result, t.sanitize)
+		}
+	}
+}
+
+// addExplainEvents adds an event of result, and an event of every row
+// of explain results. The explained sql has vars in it, so it is not
+// added if sanitize is true.
+func addExplainEvents(span oteltrace.Span, result explain.CallBackResult, sanitize bool) {
+	attrs := []attribute.KeyValue{
+		keyExplainFingerprint.String(result.Fingerprint),
+		keyExplainDigest.String(result.Digest),
+		keyExplainResults.Int(len(result.Results)),
+	}
+	if !sanitize {
+		attrs = append(attrs, keyExplainSQL.String(result.SQL))
+	}
+	if result.QueryName != "" {
+		attrs = append(attrs, keyExplainQueryName.String(result.QueryName))
+	}
+	if result.Err != nil {
+		attrs = append(attrs, keyExplainRecommend.String(result.Err.Error()))
+	}
+	span.AddEvent(explainEvent, oteltrace.WithAttributes(attrs...))
+
+	for _, r := range result.Results {
+		span.AddEvent(explainEvent, oteltrace.WithAttributes(
+			keyExplainID.Int(r.Id),
+			keyExplainSelectType.String(r.SelectType),
+			keyExplainTable.String(r.Table),
+			keyExplainType.String(r.Type),
+			keyExplainPossibleKeys.String(r.PossibleKey),
+			keyExplainKey.String(r.Key),
+			keyExplainRows.Int(r.Rows),
+			keyExplainExtra.String(r.Extra),
+		))
+	}
+}
@@ -0,0 +1,224 @@
+package trace
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/changsongl/gorm-plugin/internal/fakedriver"
+	"github.com/changsongl/gorm-plugin/query"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type user struct {
+	ID   int64
+	Name string
+}
+
+// newDryRunDB return a dry run mysql db, it never connects to the
+// server. Default transactions are skipped, so create, update and delete
+// do not begin one.
+func newDryRunDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "user:pass@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newFakeDB return a mysql db of fakedriver, every query of it returns
+// fakedriver.Rows rows.
+func newFakeDB(t *testing.T) *gorm.DB {
+	sqlDB, err := sql.Open(fakedriver.Name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestDB return a dry run db with the trace plugin of opts, and the
+// exporter of its spans.
+func newTestDB(t *testing.T, opts ...Option) (*gorm.DB, *tracetest.InMemoryExporter) {
+	db := newDryRunDB(t)
+	return db, useTracer(t, db, opts...)
+}
+
+// useTracer uses the trace plugin of opts on db, and return the exporter
+// of its spans.
+func useTracer(t *testing.T, db *gorm.DB, opts ...Option) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	if err := db.Use(New(append([]Option{TracerProviderOption(provider)}, opts...)...)); err != nil {
+		t.Fatal(err)
+	}
+	return exporter
+}
+
+// attrs return attributes of span by key.
+func attrs(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	m := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestSpan(t *testing.T) {
+	db, exporter := newTestDB(t)
+	db.Where("id = ?", 1).Find(&[]user{})
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "gorm:query" {
+		t.Errorf("span name = %q, want gorm:query", span.Name)
+	}
+	if span.Status.Code == codes.Error {
+		t.Errorf("span status = %v, want no error", span.Status)
+	}
+	if span.EndTime.Before(span.StartTime) {
+		t.Errorf("span ends at %v before it starts at %v", span.EndTime, span.StartTime)
+	}
+
+	a := attrs(span)
+	for key, want := range map[attribute.Key]attribute.Value{
+		keyDBSystem:     attribute.StringValue("mysql"),
+		keyDBStatement:  attribute.StringValue("SELECT * FROM `users` WHERE id = ?"),
+		keyDBTable:      attribute.StringValue("users"),
+		keyRowsAffected: attribute.Int64Value(0),
+		keyCallback:     attribute.StringValue("gorm:query"),
+	} {
+		if got := a[key]; got != want {
+			t.Errorf("attribute %s = %v, want %v", key, got.Emit(), want.Emit())
+		}
+	}
+}
+
+func TestSpanOfRawSQL(t *testing.T) {
+	db, exporter := newTestDB(t, DBSystemOption("tidb"), SanitizeStatementOption())
+	db.Exec("UPDATE `orders` SET state = 'paid', note = \"secret\" WHERE id = 10")
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+
+	a := attrs(spans[0])
+	for key, want := range map[attribute.Key]string{
+		keyDBSystem:    "tidb",
+		keyDBStatement: "update `orders` set state = ?, note = ? where id = ?",
+		keyDBTable:     "orders",
+		keyCallback:    "gorm:raw",
+	} {
+		if got := a[key].AsString(); got != want {
+			t.Errorf("attribute %s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestSpanError(t *testing.T) {
+	db, exporter := newTestDB(t)
+	db.Model(&user{}).Update("name", "bob")
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Status.Code != codes.Error || span.Status.Description != gorm.ErrMissingWhereClause.Error() {
+		t.Errorf("span status = %v, want error %q", span.Status, gorm.ErrMissingWhereClause)
+	}
+	if len(span.Events) != 1 || span.Events[0].Name != "exception" {
+		t.Errorf("span events = %v, want the error", span.Events)
+	}
+}
+
+func TestSpanEndTime(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tr := newTracer(&options{provider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))})
+
+	start := time.Now()
+	end := start.Add(time.Millisecond)
+	_, span := tr.tracer.Start(context.Background(), "gorm:query", oteltrace.WithTimestamp(start))
+	tr.end(span, &gorm.DB{Config: &gorm.Config{}, Statement: &gorm.Statement{}}, "gorm:query", end)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if !spans[0].EndTime.Equal(end) {
+		t.Errorf("span ends at %v, want %v", spans[0].EndTime, end)
+	}
+}
+
+func TestSpanKeepsContextValues(t *testing.T) {
+	type key struct{}
+	origin := context.WithValue(context.Background(), key{}, "origin")
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(origin, "gorm:row")
+	defer span.End()
+
+	if got := restoreContext(ctx, ctx, origin); got != origin {
+		t.Error("context is not origin when the next handler did not change it")
+	}
+
+	current := context.WithValue(ctx, key{}, "next")
+	got := restoreContext(current, ctx, origin)
+	if got.Value(key{}) != "next" {
+		t.Errorf("value = %v, want next added by the next handler", got.Value(key{}))
+	}
+	if oteltrace.SpanFromContext(got).SpanContext().IsValid() {
+		t.Error("span of the ended statement is still in the context")
+	}
+}
+
+func TestSpanWithRowsCallback(t *testing.T) {
+	db := newFakeDB(t)
+	plugin := query.New(query.RowsCallback(query.Config{NamePrefix: "gorm"}))
+	if err := db.Use(plugin); err != nil {
+		t.Fatal(err)
+	}
+	// the span is outer, rows of gorm:row are put into the context in it.
+	exporter := useTracer(t, db)
+
+	var dest []user
+	if err := db.Raw("SELECT id FROM users").Scan(&dest).Error; err != nil {
+		t.Fatal(err)
+	}
+	if spans := exporter.GetSpans(); len(spans) != 1 || spans[0].Name != "gorm:row" {
+		t.Fatalf("got spans %v, want one of gorm:row", spans)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(plugin.MetricsCollectors()...)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "gorm_rows_returned" {
+			continue
+		}
+		h := mf.Metric[0].GetHistogram()
+		if h.GetSampleCount() != 1 || h.GetSampleSum() != fakedriver.Rows {
+			t.Fatalf("rows returned count = %d, sum = %v, want 1 and %d", h.GetSampleCount(), h.GetSampleSum(), fakedriver.Rows)
+		}
+		return
+	}
+	t.Fatal("rows returned of scan not found")
+}
@@ -0,0 +1,82 @@
+package trace
+
+import (
+	"github.com/changsongl/gorm-plugin/explain"
+	"github.com/changsongl/gorm-plugin/fingerprint"
+	"github.com/changsongl/gorm-plugin/query"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// options option data
+type options struct {
+	provider  oteltrace.TracerProvider
+	dbSystem  string
+	sanitize  bool
+	explainer *explain.StatementExplainer
+	mode      query.Mode
+
+	fingerprintOpts []fingerprint.Option
+}
+
+// Option interface to apply changes on options
+type Option interface {
+	apply(*options)
+}
+
+// optFunc option function
+type optFunc func(*options)
+
+// apply implements Option
+func (f optFunc) apply(opts *options) {
+	f(opts)
+}
+
+// TracerProviderOption sets the tracer provider, default is the global
+// one of otel.GetTracerProvider.
+func TracerProviderOption(provider oteltrace.TracerProvider) Option {
+	return optFunc(func(opt *options) {
+		opt.provider = provider
+	})
+}
+
+// DBSystemOption sets db.system attribute, default is the name of gorm
+// dialector, such as mysql.
+func DBSystemOption(system string) Option {
+	return optFunc(func(opt *options) {
+		opt.dbSystem = system
+	})
+}
+
+// SanitizeStatementOption sets db.statement attribute to the normalized
+// sql of fingerprint package, so literals of raw sql are not exported.
+func SanitizeStatementOption() Option {
+	return optFunc(func(opt *options) {
+		opt.sanitize = true
+	})
+}
+
+// ANSIQuotesOption treats double quoted strings as identifiers when
+// sanitizing statements and parsing tables, like the ANSI_QUOTES sql mode
+// of MySQL. By default they are string literals.
+func ANSIQuotesOption() Option {
+	return optFunc(func(opt *options) {
+		opt.fingerprintOpts = []fingerprint.Option{fingerprint.ANSIQuotes()}
+	})
+}
+
+// ExplainOption runs EXPLAIN of every statement, and adds the results
+// as span events. It costs one more query for every statement.
+func ExplainOption(opts ...explain.Option) Option {
+	return optFunc(func(opt *options) {
+		opt.explainer = explain.NewStatementExplainer(opts...)
+	})
+}
+
+// ModeOption sets the way to install callbacks, default is
+// query.ReplaceMode. Spans of query.HookMode start at query.StartTime,
+// but the span is not in the context of the statement when it runs.
+func ModeOption(mode query.Mode) Option {
+	return optFunc(func(opt *options) {
+		opt.mode = mode
+	})
+}
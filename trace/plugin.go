@@ -0,0 +1,41 @@
+package trace
+
+import (
+	"github.com/changsongl/gorm-plugin/query"
+	"gorm.io/gorm"
+)
+
+// Plugin is a gorm plugin which can be closed
+type Plugin interface {
+	gorm.Plugin
+	Close(db *gorm.DB) error
+}
+
+// Interceptor return a query.Interceptor starting a span of every
+// statement, it can be installed with other interceptors by
+// query.InterceptorCallback.
+func Interceptor(opts ...Option) query.Interceptor {
+	return newTracer(newOptions(opts)).interceptor
+}
+
+// New a trace plugin, it starts an OpenTelemetry span from the statement
+// context of every create, query, update, delete, row and raw callback.
+func New(opts ...Option) Plugin {
+	options := newOptions(opts)
+	interceptor := newTracer(options).interceptor
+
+	cb := query.InterceptorCallback(interceptor)
+	if options.mode == query.HookMode {
+		cb = query.InterceptorHookCallback(interceptor)
+	}
+	return query.NewWithName("gorm-plugin:trace", cb)
+}
+
+// newOptions create options of opts
+func newOptions(opts []Option) *options {
+	options := &options{}
+	for _, optFunc := range opts {
+		optFunc.apply(options)
+	}
+	return options
+}